@@ -1,6 +1,7 @@
 package gobbi
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -10,7 +11,9 @@ import (
 	"path"
 	"runtime"
 	"strings"
-	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -29,24 +32,67 @@ var (
 	ErrNoPriorTest                 = fmt.Errorf("%w: no prior test", ErrTestError)
 	ErrHeaderNotPresent            = fmt.Errorf("%w: missing header", ErrTestFailure)
 	ErrHeaderValueMismatch         = fmt.Errorf("%w: header value mismatch", ErrTestFailure)
+	ErrForbiddenHeaderPresent      = fmt.Errorf("%w: forbidden header present", ErrTestFailure)
+	ErrRegexpNotMatched            = fmt.Errorf("%w: regular expression not matched", ErrTestFailure)
+	ErrInvalidRegexp               = fmt.Errorf("%w: invalid regular expression", ErrTestError)
+	ErrUnknownCast                 = fmt.Errorf("%w: unknown cast", ErrTestError)
+	ErrCastFailed                  = fmt.Errorf("%w: unable to cast", ErrTestError)
 	ErrEnvironmentVariableNotFound = fmt.Errorf("%w: environment variable not found", ErrTestError)
+	ErrRequestTimeout              = fmt.Errorf("%w: request timed out", ErrTestError)
+	ErrNoCertificates              = errors.New("no certificates found")
+	ErrUnknownCaseKey              = errors.New("unknown case key")
+	ErrInvalidHandlerKey           = errors.New("invalid response handler key")
 )
 
 type Poll struct {
 	Count *int     `yaml:"count,omitempty"`
-	Delay *float32 `yaml:"delay,omitempy"`
+	Delay *float32 `yaml:"delay,omitempty"`
 }
 
+// RedirectCount is the number of redirects a Case will follow. In YAML it
+// may be an integer or, as in gabbi, a boolean where true means
+// DefaultMaxRedirects.
+type RedirectCount int
+
+func (r *RedirectCount) UnmarshalYAML(node *yaml.Node) error {
+	var follow bool
+	if err := node.Decode(&follow); err == nil {
+		*r = 0
+		if follow {
+			*r = DefaultMaxRedirects
+		}
+		return nil
+	}
+	var count int
+	if err := node.Decode(&count); err != nil {
+		return err
+	}
+	*r = RedirectCount(count)
+	return nil
+}
+
+// errCollectedFatal is used to unwind out of a Fatalf while failures are
+// being collected.
+var errCollectedFatal = errors.New("fatal failure while collecting")
+
 func (c *Case) Errorf(format string, args ...any) {
 	_, fileName, lineNumber, _ := runtime.Caller(1)
 	baseName := path.Base(fileName)
 	format = fmt.Sprintf("%s:%d: %s", baseName, lineNumber, format)
+	if c.collecting {
+		c.collected = append(c.collected, fmt.Sprintf(format, args...))
+		return
+	}
 	if !c.Xfail {
 		c.GetTest().Errorf(format, args...)
 	} else {
 		s := fmt.Sprintf(format, args...)
 		c.SetXFailure()
-		c.GetTest().Logf("ignoring error in xfail: %s", s)
+		if x, ok := c.GetTest().(XfailReporter); ok {
+			x.Xfailf("expected failure: %s", s)
+		} else {
+			c.GetTest().Logf("ignoring error in xfail: %s", s)
+		}
 	}
 }
 
@@ -54,11 +100,18 @@ func (c *Case) Fatalf(format string, args ...any) {
 	_, fileName, lineNumber, _ := runtime.Caller(1)
 	baseName := path.Base(fileName)
 	format = fmt.Sprintf("%s:%d: %s", baseName, lineNumber, format)
+	if c.collecting {
+		c.collected = append(c.collected, fmt.Sprintf(format, args...))
+		panic(errCollectedFatal)
+	}
 	if !c.Xfail {
 		c.GetTest().Fatalf(format, args...)
 	} else {
 		s := fmt.Sprintf(format, args...)
 		c.SetXFailure()
+		if x, ok := c.GetTest().(XfailReporter); ok {
+			x.Xfailf("expected failure: %s", s)
+		}
 		c.GetTest().Skipf("skipping in xfail after: %s", s)
 	}
 }
@@ -82,27 +135,45 @@ type Case struct {
 	Xfail           bool                   `yaml:"xfail,omitempty"`
 	Verbose         bool                   `yaml:"verbose,omitempty"`
 	Skip            *string                `yaml:"skip,omitempty"`
-	CertValidated   bool                   `yaml:"cert_validated,omitempty"`
-	Redirects       int                    `yaml:"redirects,omitempty"`
+	CertValidated   *bool                  `yaml:"cert_validated,omitempty"`
+	Redirects       RedirectCount          `yaml:"redirects,omitempty"`
 	UsePriorTest    *bool                  `yaml:"use_prior_test,omitempty"`
 	Poll            Poll                   `yaml:"poll,omitempty"`
+	Timeout         float64                `yaml:"timeout,omitempty"`
+	// Capture maps the names of vars to set from the response to a
+	// JSONPath or a substitution to set them to.
+	Capture map[string]string `yaml:"capture,omitempty"`
 	// SSL is ignored but we parse it for compatibility with gabbi.
 	SSL *bool `yaml:"ssl,omitempty"`
-	// TODO: Ideally these would be pluggable, as with gabbi, but it is too
-	// hard to figure out how to do that, so we'll fake it for now.
+	// HandlerData holds the values of keys for response handlers added
+	// with RegisterResponseHandler.
+	HandlerData map[string]interface{} `yaml:",inline"`
+	// The built in response handlers have their own fields. Those added
+	// with RegisterResponseHandler use HandlerData.
 	ResponseHeaders          map[string]string      `yaml:"response_headers,omitempty"`
 	ResponseForbiddenHeaders []string               `yaml:"response_forbidden_headers,omitempty"`
 	ResponseStrings          []string               `yaml:"response_strings,omitempty"`
 	ResponseJSONPaths        map[string]interface{} `yaml:"response_json_paths,omitempty"`
+	ResponseJSONSchema       interface{}            `yaml:"response_json_schema,omitempty"`
+	ResponseXPaths           map[string]interface{} `yaml:"response_xpaths,omitempty"`
 	responseBody             io.ReadSeeker
 	responseHeader           http.Header
+	response                 *http.Response
+	finalURL                 string
 	done                     bool
 	prior                    *Case
 	suiteFileName            string
-	test                     *testing.T
-	parent                   *testing.T
+	test                     Reporter
+	parent                   Reporter
 	defaultURLBase           string
 	xfailure                 bool
+	innerFixtures            []InnerFixture
+	openAPI                  *OpenAPI
+	vars                     *suiteVars
+	generated                map[string]interface{}
+	collecting               bool
+	collected                []string
+	ctx                      context.Context
 }
 
 func (c *Case) NewRequestDataHandler() (RequestDataHandler, error) {
@@ -115,10 +186,8 @@ func (c *Case) NewRequestDataHandler() (RequestDataHandler, error) {
 		default:
 			return requestHandlers["nil"], nil
 		}
-	case strings.HasPrefix(x, "application/json"):
-		return requestHandlers["json"], nil
-	case strings.HasPrefix(x, "text/plain"):
-		return requestHandlers["text"], nil
+	case lookupRequestDataHandler(x) != nil:
+		return lookupRequestDataHandler(x), nil
 	default:
 		return requestHandlers["binary"], nil
 	}
@@ -140,6 +209,24 @@ func (c *Case) GetResponseBody() io.ReadSeeker {
 	return c.responseBody
 }
 
+// GetHandlerData returns the value of key, a key registered with
+// RegisterResponseHandler, and whether the case sets it.
+func (c *Case) GetHandlerData(key string) (interface{}, bool) {
+	v, ok := c.HandlerData[key]
+	return v, ok
+}
+
+// SetResponse records the response to the most recent request.
+func (c *Case) SetResponse(r *http.Response) {
+	c.response = r
+}
+
+// GetResponse returns the response to the most recent request, or nil if
+// there has not been one. Its body has been read and closed.
+func (c *Case) GetResponse() *http.Response {
+	return c.response
+}
+
 func (c *Case) SetResponseHeader(h http.Header) {
 	c.responseHeader = h
 }
@@ -163,6 +250,66 @@ func (c *Case) ParsedURL() *url.URL {
 	return u
 }
 
+// pollSettings returns the number of attempts to make and the delay between
+// them, using gabbi's defaults when poll is not set.
+func (c *Case) pollSettings() (int, time.Duration) {
+	count := 1
+	if c.Poll.Count != nil && *c.Poll.Count > 1 {
+		count = *c.Poll.Count
+	}
+	delay := float64(DefaultPollDelay)
+	if c.Poll.Delay != nil {
+		delay = float64(*c.Poll.Delay)
+	}
+	return count, time.Duration(delay * float64(time.Second))
+}
+
+// requestTimeout returns how long a request may take, including reading
+// the response body, which is DefaultHTTPTimeout when timeout is not set.
+func (c *Case) requestTimeout() time.Duration {
+	timeout := float64(DefaultHTTPTimeout)
+	if c.Timeout > 0 {
+		timeout = c.Timeout
+	}
+	return time.Duration(timeout * float64(time.Second))
+}
+
+// SetContext sets the context within which requests for the case are
+// made, ending them if it is cancelled or its deadline passes.
+func (c *Case) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// Context returns the context set with SetContext, or a background
+// context if there is none.
+func (c *Case) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// IsCertValidated reports whether the server certificate should be
+// verified, which it is unless cert_validated is false.
+func (c *Case) IsCertValidated() bool {
+	return c.CertValidated == nil || *c.CertValidated
+}
+
+// SetFinalURL records the URL of the last request made when following
+// redirects.
+func (c *Case) SetFinalURL(u string) {
+	c.finalURL = u
+}
+
+// GetFinalURL returns the URL the response came from, which differs from
+// URL when redirects were followed.
+func (c *Case) GetFinalURL() string {
+	if c.finalURL == "" {
+		return c.URL
+	}
+	return c.finalURL
+}
+
 func (c *Case) SetDone() {
 	c.done = true
 }
@@ -190,19 +337,49 @@ func (c *Case) SetSuiteFileName(fileName string) {
 	c.suiteFileName = fileName
 }
 
-func (c *Case) SetTest(t *testing.T, parent *testing.T) {
+// SetTest sets where the outcome of the case is reported, and the
+// Reporter of the suite running it.
+func (c *Case) SetTest(t Reporter, parent Reporter) {
 	c.test = t
 	c.parent = parent
 }
 
-func (c *Case) GetTest() *testing.T {
+func (c *Case) GetTest() Reporter {
 	return c.test
 }
 
-func (c *Case) GetParent() *testing.T {
+func (c *Case) GetParent() Reporter {
 	return c.parent
 }
 
+// CollectFailures runs f with Errorf and Fatalf recording failures rather
+// than reporting them to the test, and returns what was recorded. A Fatalf
+// stops f.
+func (c *Case) CollectFailures(f func()) (failures []string) {
+	c.collecting = true
+	c.collected = nil
+	defer func() {
+		failures = c.collected
+		c.collecting = false
+		c.collected = nil
+		if r := recover(); r != nil && r != errCollectedFatal {
+			panic(r)
+		}
+	}()
+	f()
+	return failures
+}
+
+// SetInnerFixtures sets the fixtures to run around the case.
+func (c *Case) SetInnerFixtures(f []InnerFixture) {
+	c.innerFixtures = f
+}
+
+// SetOpenAPI sets the OpenAPI document the response should conform to.
+func (c *Case) SetOpenAPI(o *OpenAPI) {
+	c.openAPI = o
+}
+
 func (c *Case) SetXFailure() {
 	c.xfailure = true
 }
@@ -0,0 +1,340 @@
+package gobbi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrNoCassetteInteraction = fmt.Errorf("%w: no interaction in cassette", ErrTestError)
+	ErrCassetteUnsupported   = errors.New("requester cannot use a cassette")
+)
+
+// DefaultCassetteMatchHeaders are the request headers which, with the
+// method, URL and body, must match for a recorded response to be replayed,
+// when a Cassette does not set its own.
+var DefaultCassetteMatchHeaders = []string{"accept", "content-type"}
+
+// Cassette holds recorded requests and their responses, so suites can be
+// run without the service they test. A BaseClient records to one with
+// RecordTo. A ReplayClient answers cases from one.
+type Cassette struct {
+	// MatchHeaders are the request headers which must match. If empty,
+	// DefaultCassetteMatchHeaders are used.
+	MatchHeaders []string      `yaml:"match_headers,omitempty"`
+	Interactions []Interaction `yaml:"interactions"`
+	lock         sync.Mutex
+	used         map[int]bool
+}
+
+// Interaction is one recorded request and its response.
+type Interaction struct {
+	Request  CassetteRequest  `yaml:"request"`
+	Response CassetteResponse `yaml:"response"`
+}
+
+type CassetteRequest struct {
+	Method  string            `yaml:"method"`
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Body    CassetteBody      `yaml:"body,omitempty"`
+}
+
+type CassetteResponse struct {
+	Status  int                 `yaml:"status"`
+	Headers map[string][]string `yaml:"headers,omitempty"`
+	Body    CassetteBody        `yaml:"body,omitempty"`
+}
+
+// CassetteBody is a body, as a string if it is UTF-8 and otherwise in
+// base64.
+type CassetteBody struct {
+	Text   string `yaml:"text,omitempty"`
+	Base64 string `yaml:"base64,omitempty"`
+}
+
+func newCassetteBody(body []byte) CassetteBody {
+	if utf8.Valid(body) {
+		return CassetteBody{Text: string(body)}
+	}
+	return CassetteBody{Base64: base64.StdEncoding.EncodeToString(body)}
+}
+
+// Bytes returns the body.
+func (b CassetteBody) Bytes() ([]byte, error) {
+	if b.Base64 != "" {
+		return base64.StdEncoding.DecodeString(b.Base64)
+	}
+	return []byte(b.Text), nil
+}
+
+// LoadCassette reads a Cassette from the YAML file fileName.
+func LoadCassette(fileName string) (*Cassette, error) {
+	data, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+	cassette := &Cassette{}
+	dec := yaml.NewDecoder(data)
+	dec.KnownFields(true)
+	err = dec.Decode(cassette)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return cassette, nil
+}
+
+// Save writes the Cassette to the YAML file fileName.
+func (c *Cassette) Save(fileName string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, out, 0o644)
+}
+
+// Add records the request rq, whose body was reqBody, and its response
+// resp, whose body was respBody. Request headers holding credentials, such
+// as authorization and cookie, are not recorded, so cannot be matched.
+func (c *Cassette) Add(rq *http.Request, reqBody []byte, resp *http.Response, respBody []byte) {
+	interaction := Interaction{
+		Request: CassetteRequest{
+			Method: rq.Method,
+			URL:    normalizeCassetteURL(rq.URL),
+			Body:   newCassetteBody(reqBody),
+		},
+		Response: CassetteResponse{
+			Status:  resp.StatusCode,
+			Headers: resp.Header.Clone(),
+			Body:    newCassetteBody(respBody),
+		},
+	}
+	for name := range rq.Header {
+		lowerName := strings.ToLower(name)
+		if _, ok := secretRequestHeaders[lowerName]; ok || unrecordedRequestHeaders[lowerName] {
+			continue
+		}
+		if interaction.Request.Headers == nil {
+			interaction.Request.Headers = map[string]string{}
+		}
+		interaction.Request.Headers[lowerName] = strings.Join(rq.Header.Values(name), ", ")
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Interactions = append(c.Interactions, interaction)
+}
+
+// RoundTrip answers rq with the response of the first interaction matching
+// it which has not been replayed, or, if they all have, the last. This
+// lets a sequence of identical requests, as when polling, have different
+// responses.
+func (c *Cassette) RoundTrip(rq *http.Request) (*http.Response, error) {
+	if err := rq.Context().Err(); err != nil {
+		return nil, err
+	}
+	var reqBody []byte
+	if rq.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(rq.Body)
+		rq.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.lock.Lock()
+	found := -1
+	for i := range c.Interactions {
+		if !c.matches(c.Interactions[i].Request, rq, reqBody) {
+			continue
+		}
+		found = i
+		if !c.used[i] {
+			break
+		}
+	}
+	if found != -1 {
+		if c.used == nil {
+			c.used = map[int]bool{}
+		}
+		c.used[found] = true
+	}
+	c.lock.Unlock()
+	if found == -1 {
+		return nil, fmt.Errorf("%w: for %s %s", ErrNoCassetteInteraction, rq.Method, rq.URL)
+	}
+
+	recorded := c.Interactions[found].Response
+	body, err := recorded.Body.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	for name, values := range recorded.Headers {
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+		StatusCode:    recorded.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       rq,
+	}, nil
+}
+
+// matches reports whether the recorded request is the same as rq, whose
+// body is reqBody. JSON bodies match if they are equal as JSON. Multipart
+// bodies are not compared as their boundaries are random.
+func (c *Cassette) matches(recorded CassetteRequest, rq *http.Request, reqBody []byte) bool {
+	if recorded.Method != rq.Method || recorded.URL != normalizeCassetteURL(rq.URL) {
+		return false
+	}
+	matchHeaders := c.MatchHeaders
+	if len(matchHeaders) == 0 {
+		matchHeaders = DefaultCassetteMatchHeaders
+	}
+	for _, name := range matchHeaders {
+		name = strings.ToLower(name)
+		if withoutBoundary(name, recorded.Headers[name]) != withoutBoundary(name, strings.Join(rq.Header.Values(name), ", ")) {
+			return false
+		}
+	}
+
+	recordedBody, err := recorded.Body.Bytes()
+	if err != nil {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(rq.Header.Get("content-type"))
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		return true
+	case isJSONContentType(mediaType):
+		var recordedJSON, requestJSON interface{}
+		if json.Unmarshal(recordedBody, &recordedJSON) == nil && json.Unmarshal(reqBody, &requestJSON) == nil {
+			return cmp.Equal(recordedJSON, requestJSON)
+		}
+	}
+	return bytes.Equal(recordedBody, reqBody)
+}
+
+// withoutBoundary returns value, the value of the header name, without the
+// boundary if it is a multipart content-type.
+func withoutBoundary(name, value string) string {
+	if name != "content-type" {
+		return value
+	}
+	mediaType, params, err := mime.ParseMediaType(value)
+	if err != nil || params["boundary"] == "" {
+		return value
+	}
+	delete(params, "boundary")
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// normalizeCassetteURL returns u with its query parameters sorted, so the
+// order they are given in does not matter.
+func normalizeCassetteURL(u *url.URL) string {
+	normal := *u
+	if normal.RawQuery != "" {
+		normal.RawQuery = normal.Query().Encode()
+	}
+	return normal.String()
+}
+
+// cassetteRecorder is an http.RoundTripper which adds the requests it
+// sends with next, and their responses, to cassette.
+type cassetteRecorder struct {
+	cassette *Cassette
+	next     http.RoundTripper
+}
+
+func (r *cassetteRecorder) RoundTrip(rq *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	out := rq
+	if rq.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(rq.Body)
+		rq.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		out = rq.Clone(rq.Context())
+		out.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	resp, err := r.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	r.cassette.Add(rq, reqBody, resp, respBody)
+	return resp, nil
+}
+
+// Replay makes the client answer requests from cassette, instead of
+// sending them. It is how a ReplayClient, which should usually be used
+// instead, replays.
+func (b *BaseClient) Replay(cassette *Cassette) {
+	b.Client.Transport = cassette
+	b.insecureClient = nil
+}
+
+// RecordTo makes the client add the requests it sends, and their
+// responses, to cassette. Recording is done by the transport, so only a
+// BaseClient can record.
+func (b *BaseClient) RecordTo(cassette *Cassette) {
+	next := b.Client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	b.Client.Transport = &cassetteRecorder{cassette: cassette, next: next}
+	b.insecureClient = nil
+}
+
+// ReplayClient is a Requester which answers each Case from Cassette
+// instead of the network. Cases are resolved and their responses checked
+// as with a BaseClient, which it uses with Cassette as its transport.
+type ReplayClient struct {
+	*BaseClient
+	Cassette *Cassette
+}
+
+// NewReplayClient creates a ReplayClient which answers cases from
+// cassette.
+func NewReplayClient(cassette *Cassette) *ReplayClient {
+	return newReplayClient(NewClient(), cassette)
+}
+
+// newReplayClient creates a ReplayClient which answers cases from cassette
+// using b, keeping its other settings, such as a cookie jar.
+func newReplayClient(b *BaseClient, cassette *Cassette) *ReplayClient {
+	b.Replay(cassette)
+	return &ReplayClient{BaseClient: b, Cassette: cassette}
+}
@@ -0,0 +1,314 @@
+// Command gobbi runs gobbi YAML suites against a running service, outside
+// of go test.
+//
+// Usage:
+//
+//	gobbi run [-v] [-format text|junit|tap] [-o file] [-record file | -replay file] http://host:port suite1.yaml [suite2.yaml ...]
+//	gobbi generate [-o file] openapi.yaml
+//	gobbi record [-o file] [-listen addr | -requests file] http://host:port
+//
+// By default run prints the result of each case, followed by a summary.
+// With -format a JUnit XML or TAP report is written instead, to standard
+// output or the file named by -o. The exit status is 1 if any case failed
+// and 2 for usage errors. With -record the requests made, and their
+// responses, are saved to a cassette file. With -replay requests are
+// answered from a cassette file instead of by the service.
+//
+// generate writes a skeleton suite with a case for each operation in an
+// OpenAPI 3 document, to standard output or the file named by -o. The
+// suite checks responses against the document.
+//
+// record writes a suite of requests made to a service and assertions
+// inferred from its responses, to standard output or the file named by
+// -o. The requests are either made through a proxy to the service,
+// listening on -listen, until gobbi is interrupted, or read from the file
+// named by -requests, one curl command line per line.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/cdent/gobbi"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	runUsage      = `usage: gobbi run [-v] [-format text|junit|tap] [-o file] [-record file | -replay file] <url> <suite.yaml> [<suite.yaml> ...]`
+	generateUsage = `usage: gobbi generate [-o file] <openapi.yaml>`
+	recordUsage   = `usage: gobbi record [-o file] [-listen addr | -requests file] <url>`
+	usage         = runUsage + "\n" + generateUsage + "\n" + recordUsage
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "run":
+			return runSuites(args[1:], stdout, stderr)
+		case "generate":
+			return generate(args[1:], stdout, stderr)
+		case "record":
+			return record(args[1:], stdout, stderr)
+		}
+	}
+	fmt.Fprintln(stderr, usage)
+	return 2
+}
+
+func runSuites(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, runUsage)
+		flags.PrintDefaults()
+	}
+	verbose := flags.Bool("v", false, "show log messages for every case")
+	format := flags.String("format", "text", "output format: text, junit or tap")
+	outFile := flags.String("o", "", "write output to `file` instead of standard output")
+	recordFile := flags.String("record", "", "save requests and responses to the cassette `file`")
+	replayFile := flags.String("replay", "", "answer requests from the cassette `file`")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 {
+		flags.Usage()
+		return 2
+	}
+	if *format != "text" && *format != "junit" && *format != "tap" {
+		fmt.Fprintf(stderr, "unknown format: %s\n", *format)
+		return 2
+	}
+	if *recordFile != "" && *replayFile != "" {
+		fmt.Fprintln(stderr, "only one of -record and -replay may be used")
+		return 2
+	}
+
+	out := stdout
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to open output: %v\n", err)
+			return 2
+		}
+		defer f.Close()
+		out = f
+	}
+
+	urlBase := flags.Arg(0)
+	multi, err := gobbi.NewMultiSuiteFromYAMLFiles(nil, urlBase, flags.Args()[1:]...)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to load suites: %v\n", err)
+		return 2
+	}
+	cassette := &gobbi.Cassette{}
+	switch {
+	case *replayFile != "":
+		cassette, err = gobbi.LoadCassette(*replayFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to load cassette: %v\n", err)
+			return 2
+		}
+		err = multi.Replay(cassette)
+	case *recordFile != "":
+		err = multi.RecordTo(cassette)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to use cassette: %v\n", err)
+		return 2
+	}
+	runAll := func(r gobbi.Reporter) error {
+		multi.Run(r)
+		if *recordFile == "" {
+			return nil
+		}
+		return cassette.Save(*recordFile)
+	}
+
+	if *format == "text" {
+		reporter := gobbi.NewTextReporter(out, *verbose)
+		err = runAll(reporter)
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to save cassette: %v\n", err)
+			return 1
+		}
+		fmt.Fprintln(out, reporter.Summary())
+		if reporter.Failed() {
+			return 1
+		}
+		return 0
+	}
+
+	textReporter := gobbi.NewTextReporter(io.Discard, false)
+	reporter := gobbi.NewRecordingReporter(textReporter)
+	err = runAll(reporter)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to save cassette: %v\n", err)
+		return 1
+	}
+	switch *format {
+	case "junit":
+		err = reporter.WriteJUnit(out)
+	case "tap":
+		err = reporter.WriteTAP(out)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to write %s: %v\n", *format, err)
+		return 1
+	}
+	if textReporter.Failed() {
+		return 1
+	}
+	return 0
+}
+
+func generate(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, generateUsage)
+		flags.PrintDefaults()
+	}
+	outFile := flags.String("o", "", "write the suite to `file` instead of standard output")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	specFile := flags.Arg(0)
+	spec, err := gobbi.LoadOpenAPI(specFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to load openapi: %v\n", err)
+		return 2
+	}
+	suite := spec.GenerateSuiteYAML()
+	// The suite refers to the document relative to where it is written.
+	suite.OpenAPI = specFile
+	if *outFile != "" {
+		rel, err := relativeTo(filepath.Dir(*outFile), specFile)
+		if err == nil {
+			suite.OpenAPI = rel
+		}
+	}
+
+	return writeSuite(suite, *outFile, stdout, stderr)
+}
+
+// writeSuite writes suite as YAML to the file named outFile, or to stdout
+// if it is empty, and returns the exit status.
+func writeSuite(suite *gobbi.SuiteYAML, outFile string, stdout, stderr io.Writer) int {
+	out := stdout
+	if outFile != "" {
+		f, err := os.Create(outFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to open output: %v\n", err)
+			return 2
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := yaml.NewEncoder(out)
+	err := enc.Encode(suite)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to write suite: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// relativeTo returns the path of fileName relative to dir.
+func relativeTo(dir, fileName string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	absFile, err := filepath.Abs(fileName)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absDir, absFile)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func record(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("record", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, recordUsage)
+		flags.PrintDefaults()
+	}
+	outFile := flags.String("o", "", "write the suite to `file` instead of standard output")
+	listen := flags.String("listen", "localhost:8081", "`address` for the recording proxy to listen on")
+	requestsFile := flags.String("requests", "", "send the requests in `file` instead of running a proxy")
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	recorder := gobbi.NewRecorder(flags.Arg(0))
+	if *requestsFile != "" {
+		f, err := os.Open(*requestsFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to open requests: %v\n", err)
+			return 2
+		}
+		defer f.Close()
+		err = recorder.DoLines(http.DefaultClient, f)
+		if err != nil {
+			fmt.Fprintf(stderr, "unable to record requests: %v\n", err)
+			return 1
+		}
+		return writeSuite(recorder.SuiteYAML(), *outFile, stdout, stderr)
+	}
+
+	proxy, err := recorder.Proxy()
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid url: %v\n", err)
+		return 2
+	}
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(stderr, "unable to listen: %v\n", err)
+		return 2
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Handler: proxy}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	fmt.Fprintf(stderr, "recording requests to %s made to http://%s, interrupt to stop\n", flags.Arg(0), listener.Addr())
+	err = server.Serve(listener)
+	if err != http.ErrServerClosed {
+		fmt.Fprintf(stderr, "proxy failed: %v\n", err)
+		return 1
+	}
+	return writeSuite(recorder.SuiteYAML(), *outFile, stdout, stderr)
+}
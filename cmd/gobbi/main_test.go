@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const suiteYAML = `
+tests:
+- name: passes
+  GET: /
+- name: fails
+  GET: /
+  status: 404
+- name: skips
+  skip: not today
+  GET: /
+- name: xfails
+  xfail: true
+  GET: /
+  status: 500
+`
+
+func TestRun(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(func() { ts.Close() })
+	suiteFile := filepath.Join(t.TempDir(), "cli.yaml")
+	err := os.WriteFile(suiteFile, []byte(suiteYAML), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	status := run([]string{"run", ts.URL, suiteFile}, stdout, stderr)
+	if status != 1 {
+		t.Errorf("expected exit status 1, got %d: %s", status, stderr)
+	}
+	output := stdout.String()
+	for _, expected := range []string{
+		"PASS: cli/passes\n",
+		"FAIL: cli/fails\n",
+		"SKIP: cli/skips\n",
+		"XFAIL: cli/xfails\n",
+		"FAIL: cli\n",
+		"1 passed, 1 failed, 1 skipped, 1 xfailed\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestRunReports(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(func() { ts.Close() })
+	dir := t.TempDir()
+	suiteFile := filepath.Join(dir, "cli.yaml")
+	err := os.WriteFile(suiteFile, []byte(suiteYAML), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reports := map[string][]string{
+		"junit": {
+			`<testsuites tests="4" failures="1" skipped="2"`,
+			`<failure message="`,
+			`type="xfail">`,
+		},
+		"tap": {
+			"1..4\n",
+			"ok 1 - cli/passes\n",
+			"not ok 2 - cli/fails\n",
+			"ok 3 - cli/skips # SKIP <skips> skipping: not today\n",
+			"not ok 4 - cli/xfails # TODO expected failure: ",
+		},
+	}
+	for format, expectations := range reports {
+		outFile := filepath.Join(dir, format)
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		status := run([]string{"run", "-format", format, "-o", outFile, ts.URL, suiteFile}, stdout, stderr)
+		if status != 1 {
+			t.Errorf("expected exit status 1 for %s, got %d: %s", format, status, stderr)
+		}
+		output, err := os.ReadFile(outFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range expectations {
+			if !strings.Contains(string(output), expected) {
+				t.Errorf("expected %q in %s output:\n%s", expected, format, output)
+			}
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	status := run([]string{"run", "http://localhost"}, stdout, stderr)
+	if status != 2 {
+		t.Errorf("expected exit status 2, got %d", status)
+	}
+	if !strings.Contains(stderr.String(), "usage:") {
+		t.Errorf("expected usage, got %s", stderr)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	outFile := filepath.Join(dir, "generated.yaml")
+	specFile, err := filepath.Abs("../../testdata/openapi/gobbi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	status := run([]string{"generate", "-o", outFile, specFile}, stdout, stderr)
+	if status != 0 {
+		t.Fatalf("expected exit status 0, got %d: %s", status, stderr)
+	}
+	output, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(dir, specFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"openapi: " + filepath.ToSlash(rel) + "\n",
+		"- name: echoPet\n",
+		"POST: /\n",
+		"GET: /pets/1\n",
+		"$.name: Socks\n",
+	} {
+		if !strings.Contains(string(output), expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestRecordRequests(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.Header().Set("location", "http://"+r.Host+"/things/1")
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write([]byte(`{"id": 1, "name": "thing one", "parts": []}`))
+	}))
+	t.Cleanup(func() { ts.Close() })
+	dir := t.TempDir()
+	requestsFile := filepath.Join(dir, "requests")
+	requests := `# Make a thing and look at it.
+curl -X POST -H 'content-type: application/json' -d "{\"name\": \"thing one\"}" /things
+GET /things/1?verbose=true
+`
+	err := os.WriteFile(requestsFile, []byte(requests), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	status := run([]string{"record", "-requests", requestsFile, ts.URL}, stdout, stderr)
+	if status != 0 {
+		t.Fatalf("expected exit status 0, got %d: %s", status, stderr)
+	}
+	output := stdout.String()
+	for _, expected := range []string{
+		"- name: POST /things\n",
+		"POST: /things\n",
+		"status: 201\n",
+		"location: $SCHEME://$NETLOC/things/1\n",
+		"name: thing one\n",
+		"GET: /things/1?verbose=true\n",
+		"$.id: 1\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "parts") {
+		t.Errorf("expected only scalar fields in output:\n%s", output)
+	}
+}
+
+func TestRunRecordReplay(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		w.Write([]byte(`{"path": "` + r.URL.Path + `"}`))
+	}))
+	dir := t.TempDir()
+	suiteFile := filepath.Join(dir, "cli.yaml")
+	err := os.WriteFile(suiteFile, []byte(`
+tests:
+- name: one
+  GET: /one
+  response_json_paths:
+      $.path: /one
+- name: two
+  GET: /two
+  response_json_paths:
+      $.path: /two
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassetteFile := filepath.Join(dir, "cassette.yaml")
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	status := run([]string{"run", "-record", cassetteFile, ts.URL, suiteFile}, stdout, stderr)
+	if status != 0 {
+		t.Fatalf("expected exit status 0 when recording, got %d: %s%s", status, stdout, stderr)
+	}
+
+	// Replay without the service.
+	ts.Close()
+	stdout.Reset()
+	status = run([]string{"run", "-replay", cassetteFile, ts.URL, suiteFile}, stdout, stderr)
+	if status != 0 {
+		t.Fatalf("expected exit status 0 when replaying, got %d: %s%s", status, stdout, stderr)
+	}
+	if !strings.Contains(stdout.String(), "2 passed") {
+		t.Errorf("expected 2 passed in output:\n%s", stdout)
+	}
+}
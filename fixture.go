@@ -0,0 +1,128 @@
+package gobbi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrUnknownFixture = errors.New("unknown fixture")
+	ErrFixtureFailed  = fmt.Errorf("%w: fixture failed", ErrTestError)
+)
+
+// fixtures and innerFixtures map the names used in the fixtures and
+// inner_fixtures keys of suite YAML to implementations. They are populated
+// by RegisterFixture and RegisterInnerFixture.
+var (
+	fixtures      = map[string]Fixture{}
+	innerFixtures = map[string]InnerFixture{}
+)
+
+// A Fixture sets up and tears down state around all the cases in a Suite.
+// The same Fixture is used by every Suite which names it, and suites in a
+// MultiSuite run in parallel, so any state should be kept per Suite.
+type Fixture interface {
+	Start(*Suite) error
+	Stop(*Suite) error
+}
+
+// An InnerFixture runs around every Case in a Suite. After is called even
+// when the Case fails, with the response if there is one. The body of the
+// response is also available from Case.GetResponseBody.
+type InnerFixture interface {
+	Before(*Case) error
+	After(*Case, *http.Response) error
+}
+
+// RegisterFixture makes f available to suites under name. It should be
+// called before suites are created, for example in init or TestMain.
+func RegisterFixture(name string, f Fixture) {
+	fixtures[name] = f
+}
+
+// RegisterInnerFixture makes f available to suites under name. It should
+// be called before suites are created, for example in init or TestMain.
+func RegisterInnerFixture(name string, f InnerFixture) {
+	innerFixtures[name] = f
+}
+
+// lookupFixtures turns fixture names into registered Fixtures.
+func lookupFixtures(names []string) ([]Fixture, error) {
+	found := make([]Fixture, len(names))
+	for i, name := range names {
+		f, ok := fixtures[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFixture, name)
+		}
+		found[i] = f
+	}
+	return found, nil
+}
+
+// lookupInnerFixtures turns inner fixture names into registered
+// InnerFixtures.
+func lookupInnerFixtures(names []string) ([]InnerFixture, error) {
+	found := make([]InnerFixture, len(names))
+	for i, name := range names {
+		f, ok := innerFixtures[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFixture, name)
+		}
+		found[i] = f
+	}
+	return found, nil
+}
+
+// startFixtures starts the fixtures of s in order, returning a function
+// that stops, in reverse order, those that started. If a fixture fails to
+// start, those already started are stopped and an error is returned.
+func (s *Suite) startFixtures() (func() error, error) {
+	started := 0
+	stop := func() error {
+		var stopErr error
+		for i := started - 1; i >= 0; i-- {
+			err := s.Fixtures[i].Stop(s)
+			if err != nil && stopErr == nil {
+				stopErr = fmt.Errorf("%w: stopping %T: %v", ErrFixtureFailed, s.Fixtures[i], err)
+			}
+		}
+		return stopErr
+	}
+	for _, f := range s.Fixtures {
+		err := f.Start(s)
+		if err != nil {
+			startErr := fmt.Errorf("%w: starting %T: %v", ErrFixtureFailed, f, err)
+			if stopErr := stop(); stopErr != nil {
+				return nil, fmt.Errorf("%v; %v", startErr, stopErr)
+			}
+			return nil, startErr
+		}
+		started++
+	}
+	return stop, nil
+}
+
+// startInnerFixtures calls Before on the inner fixtures of c in order,
+// returning a function which calls After, in reverse order, on those that
+// succeeded. Failures are reported on c.
+func (c *Case) startInnerFixtures() func() {
+	started := 0
+	stop := func() {
+		for i := started - 1; i >= 0; i-- {
+			err := c.innerFixtures[i].After(c, c.GetResponse())
+			if err != nil {
+				c.Errorf("%v: after %T: %v", ErrFixtureFailed, c.innerFixtures[i], err)
+			}
+		}
+	}
+	for _, f := range c.innerFixtures {
+		err := f.Before(c)
+		if err != nil {
+			stop()
+			c.Fatalf("%v: before %T: %v", ErrFixtureFailed, f, err)
+		}
+		started++
+	}
+	return stop
+}
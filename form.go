@@ -0,0 +1,152 @@
+package gobbi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FormDataHandler encodes data, a map of field names to values or lists of
+// values, as application/x-www-form-urlencoded.
+type FormDataHandler struct{}
+
+// MultipartDataHandler encodes data, a map of field names to values or
+// lists of values, as multipart/form-data. Values starting with <@ are
+// sent as the named file.
+type MultipartDataHandler struct{}
+
+// typedBody is a request body with the content-type it must be sent with,
+// which replaces the one in the request headers.
+type typedBody struct {
+	io.Reader
+	contentType string
+}
+
+func (f *FormDataHandler) GetBody(c *Case) (io.Reader, error) {
+	if stringData, ok := c.Data.(string); ok {
+		// Already encoded.
+		stringData, err := StringReplace(c, stringData)
+		if err != nil {
+			return nil, err
+		}
+		return strings.NewReader(stringData), nil
+	}
+	fields, err := formFields(c)
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	for _, field := range fields {
+		values.Add(field.name, field.value)
+	}
+	return strings.NewReader(values.Encode()), nil
+}
+
+func (m *MultipartDataHandler) GetBody(c *Case) (io.Reader, error) {
+	fields, err := formFields(c)
+	if err != nil {
+		return nil, err
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, field := range fields {
+		if strings.HasPrefix(field.value, fileForDataPrefix) {
+			err = m.writeFile(c, writer, field.name, field.value)
+		} else {
+			err = writer.WriteField(field.name, field.value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	// The boundary is only known now, so the content-type is sent with the
+	// body, leaving the case's own unchanged.
+	return &typedBody{Reader: body, contentType: writer.FormDataContentType()}, nil
+}
+
+// writeFile writes a part named name containing the file named by value.
+func (m *MultipartDataHandler) writeFile(c *Case, writer *multipart.Writer, name, value string) error {
+	fh, err := c.ReadFileForData(value)
+	if err != nil {
+		return err
+	}
+	if closer, ok := fh.(io.Closer); ok {
+		defer closer.Close()
+	}
+	fileName := path.Base(strings.TrimPrefix(value, fileForDataPrefix))
+	contentType := mime.TypeByExtension(path.Ext(fileName))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		escapeQuotes(name), escapeQuotes(fileName)))
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, fh)
+	return err
+}
+
+type formField struct {
+	name  string
+	value string
+}
+
+// formFields returns the fields in the data of c, after string replacement,
+// sorted by name so the body is the same each time. Each value must be a
+// scalar or a list of scalars.
+func formFields(c *Case) ([]formField, error) {
+	data, ok := c.Data.(map[string]interface{})
+	if !ok {
+		return nil, ErrDataHandlerContentMismatch
+	}
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := []formField{}
+	for _, name := range names {
+		values, ok := data[name].([]interface{})
+		if !ok {
+			values = []interface{}{data[name]}
+		}
+		newName, err := StringReplace(c, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			switch v.(type) {
+			case string, int, float64, bool:
+			default:
+				return nil, fmt.Errorf("%w: %s: not a scalar or list of scalars", ErrDataHandlerContentMismatch, name)
+			}
+			newV, err := StringReplace(c, scalarToString(v))
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, formField{name: newName, value: newV})
+		}
+	}
+	return fields, nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
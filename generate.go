@@ -0,0 +1,314 @@
+package gobbi
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// generateMethods are the methods of operations in a path item, in the
+// order their cases are generated.
+var generateMethods = []string{"get", "put", "post", "patch", "delete", "head", "options", "trace"}
+
+// maxExampleDepth limits how deep examples are built from schemas, which
+// may refer to themselves.
+const maxExampleDepth = 8
+
+var simpleJSONPathKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// GenerateSuiteYAML returns a skeleton suite with a case for each operation
+// in o, sorted by path. Each case uses a method shortcut, with path and
+// required query and header parameters filled from examples, an example
+// request body as data, the lowest success status declared and, from an
+// example JSON response, response_json_paths stubs. The cases are a
+// starting point to be edited, not a test of the API.
+func (o *OpenAPI) GenerateSuiteYAML() *SuiteYAML {
+	sy := &SuiteYAML{Tests: []Case{}}
+	base := ""
+	if bases := o.serverPaths(); len(bases) > 0 {
+		base = bases[0]
+	}
+
+	paths := openAPINode{pointer: "/paths"}
+	paths.value, _ = o.doc["paths"].(map[string]interface{})
+	templates := make([]string, 0, len(paths.value))
+	for template := range paths.value {
+		templates = append(templates, template)
+	}
+	sort.Strings(templates)
+
+	for _, template := range templates {
+		pathItem, ok := o.child(paths, template)
+		if !ok {
+			continue
+		}
+		for _, method := range generateMethods {
+			operation, ok := o.child(pathItem, method)
+			if !ok {
+				continue
+			}
+			sy.Tests = append(sy.Tests, o.generateCase(base, template, strings.ToUpper(method), pathItem, operation))
+		}
+	}
+	return sy
+}
+
+func (o *OpenAPI) generateCase(base, template, method string, pathItem, operation openAPINode) Case {
+	c := Case{}
+	c.Name, _ = operation.value["operationId"].(string)
+	if c.Name == "" {
+		c.Name = fmt.Sprintf("%s %s", method, template)
+	}
+	c.Desc, _ = operation.value["summary"].(string)
+
+	requestPath := template
+	for _, param := range o.parameters(pathItem, operation) {
+		name, _ := param.value["name"].(string)
+		example, hasExample := o.example(param)
+		switch in, _ := param.value["in"].(string); in {
+		case "path":
+			if hasExample {
+				requestPath = strings.ReplaceAll(requestPath, "{"+name+"}", url.PathEscape(scalarToString(example)))
+			}
+		case "query":
+			if required, _ := param.value["required"].(bool); required && hasExample {
+				if c.QueryParameters == nil {
+					c.QueryParameters = map[string]interface{}{}
+				}
+				c.QueryParameters[name] = example
+			}
+		case "header":
+			if required, _ := param.value["required"].(bool); required && hasExample {
+				if c.RequestHeaders == nil {
+					c.RequestHeaders = map[string]string{}
+				}
+				c.RequestHeaders[strings.ToLower(name)] = scalarToString(example)
+			}
+		}
+	}
+	setMethodShortcut(&c, method, base+requestPath)
+
+	if body, ok := o.child(operation, "requestBody"); ok {
+		if mediaType, media, ok := o.preferredMedia(body); ok {
+			if example, ok := o.example(media); ok {
+				if c.RequestHeaders == nil {
+					c.RequestHeaders = map[string]string{}
+				}
+				c.RequestHeaders["content-type"] = mediaType
+				c.Data = example
+			}
+		}
+	}
+
+	status, response, ok := o.successResponse(operation)
+	c.Status = status
+	if !ok {
+		return c
+	}
+	mediaType, media, ok := o.preferredMedia(response)
+	if !ok || !isJSONContentType(mediaType) {
+		return c
+	}
+	if example, ok := o.example(media); ok {
+		if object, ok := example.(map[string]interface{}); ok && len(object) > 0 {
+			c.ResponseJSONPaths = map[string]interface{}{}
+			flattenJSONPaths("$", object, c.ResponseJSONPaths)
+		}
+	}
+	return c
+}
+
+func setMethodShortcut(c *Case, method, requestURL string) {
+	switch method {
+	case "GET":
+		c.GET = requestURL
+	case "POST":
+		c.POST = requestURL
+	case "PUT":
+		c.PUT = requestURL
+	case "PATCH":
+		c.PATCH = requestURL
+	case "DELETE":
+		c.DELETE = requestURL
+	case "HEAD":
+		c.HEAD = requestURL
+	case "OPTIONS":
+		c.OPTIONS = requestURL
+	default:
+		c.Method = method
+		c.URL = requestURL
+	}
+}
+
+// parameters returns the parameters of operation, including those of
+// pathItem it does not override.
+func (o *OpenAPI) parameters(pathItem, operation openAPINode) []openAPINode {
+	params := []openAPINode{}
+	seen := map[string]bool{}
+	for _, owner := range []openAPINode{operation, pathItem} {
+		list, _ := owner.value["parameters"].([]interface{})
+		for i, item := range list {
+			value, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			param, ok := o.deref(openAPINode{value: value, pointer: fmt.Sprintf("%s/parameters/%d", owner.pointer, i)})
+			if !ok {
+				continue
+			}
+			key := fmt.Sprintf("%v:%v", param.value["in"], param.value["name"])
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+// successResponse returns the lowest status declared for operation,
+// preferring success, and its response. If only a default response is
+// declared the status is 200.
+func (o *OpenAPI) successResponse(operation openAPINode) (int, openAPINode, bool) {
+	responses, ok := o.child(operation, "responses")
+	if !ok {
+		return 200, openAPINode{}, false
+	}
+	best := ""
+	bestStatus := 0
+	for key := range responses.value {
+		status, err := strconv.Atoi(strings.NewReplacer("X", "0", "x", "0").Replace(key))
+		if err != nil {
+			continue
+		}
+		// A success is better than any other status, then the lowest.
+		success, bestSuccess := status/100 == 2, bestStatus/100 == 2
+		if bestStatus == 0 || success && !bestSuccess || success == bestSuccess && status < bestStatus {
+			best = key
+			bestStatus = status
+		}
+	}
+	if best == "" {
+		best = "default"
+		bestStatus = 200
+	}
+	response, ok := o.child(responses, best)
+	return bestStatus, response, ok
+}
+
+// preferredMedia returns the media type in the content of n, preferring
+// JSON, then the first in order of name.
+func (o *OpenAPI) preferredMedia(n openAPINode) (string, openAPINode, bool) {
+	content, ok := o.child(n, "content")
+	if !ok {
+		return "", openAPINode{}, false
+	}
+	mediaTypes := make([]string, 0, len(content.value))
+	for mediaType := range content.value {
+		mediaTypes = append(mediaTypes, mediaType)
+	}
+	sort.SliceStable(mediaTypes, func(i, j int) bool {
+		iJSON, jJSON := isJSONContentType(mediaTypes[i]), isJSONContentType(mediaTypes[j])
+		if iJSON != jJSON {
+			return iJSON
+		}
+		return mediaTypes[i] < mediaTypes[j]
+	})
+	for _, mediaType := range mediaTypes {
+		if media, ok := o.child(content, mediaType); ok {
+			return mediaType, media, true
+		}
+	}
+	return "", openAPINode{}, false
+}
+
+// example returns an example for n, a parameter, media type or schema:
+// its example, the first of its examples, or one made from its schema.
+func (o *OpenAPI) example(n openAPINode) (interface{}, bool) {
+	if example, ok := n.value["example"]; ok {
+		return example, true
+	}
+	if examples, ok := o.child(n, "examples"); ok {
+		names := make([]string, 0, len(examples.value))
+		for name := range examples.value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if example, ok := o.child(examples, name); ok {
+				if value, ok := example.value["value"]; ok {
+					return value, true
+				}
+			}
+		}
+	}
+	if schema, ok := o.child(n, "schema"); ok {
+		return o.schemaExample(schema, 0)
+	}
+	return nil, false
+}
+
+// schemaExample returns an example of a value matching schema: its
+// example, its default, or, for objects and arrays, one made from the
+// examples of their parts.
+func (o *OpenAPI) schemaExample(schema openAPINode, depth int) (interface{}, bool) {
+	if depth > maxExampleDepth {
+		return nil, false
+	}
+	for _, key := range []string{"example", "default"} {
+		if example, ok := schema.value[key]; ok {
+			return example, true
+		}
+	}
+	if enum, ok := schema.value["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[0], true
+	}
+	if items, ok := o.child(schema, "items"); ok {
+		if example, ok := o.schemaExample(items, depth+1); ok {
+			return []interface{}{example}, true
+		}
+		return nil, false
+	}
+	properties, ok := o.child(schema, "properties")
+	if !ok {
+		return nil, false
+	}
+	object := map[string]interface{}{}
+	for name := range properties.value {
+		if property, ok := o.child(properties, name); ok {
+			if example, ok := o.schemaExample(property, depth+1); ok {
+				object[name] = example
+			}
+		}
+	}
+	if len(object) == 0 {
+		return nil, false
+	}
+	return object, true
+}
+
+// flattenJSONPaths adds a JSONPath for each value in object to paths,
+// descending into objects. Lists are compared as a whole.
+func flattenJSONPaths(prefix string, object map[string]interface{}, paths map[string]interface{}) {
+	for key, value := range object {
+		path := jsonPathChild(prefix, key)
+		if inner, ok := value.(map[string]interface{}); ok && len(inner) > 0 {
+			flattenJSONPaths(path, inner, paths)
+			continue
+		}
+		paths[path] = value
+	}
+}
+
+// jsonPathChild returns the JSONPath of the member key of the object at
+// prefix.
+func jsonPathChild(prefix, key string) string {
+	if simpleJSONPathKey.MatchString(key) {
+		return prefix + "." + key
+	}
+	return fmt.Sprintf("%s['%s']", prefix, strings.ReplaceAll(key, "'", `\'`))
+}
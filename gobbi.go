@@ -1,27 +1,58 @@
 package gobbi
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type SuiteYAML struct {
-	Defaults Case
-	Fixtures interface{}
-	Tests    []Case
+	Defaults      Case                   `yaml:"defaults,omitempty"`
+	Fixtures      []string               `yaml:"fixtures,omitempty"`
+	InnerFixtures []string               `yaml:"inner_fixtures,omitempty"`
+	TLS           *SuiteTLS              `yaml:"tls,omitempty"`
+	CookieJar     bool                   `yaml:"cookie_jar,omitempty"`
+	Timeout       float64                `yaml:"timeout,omitempty"`
+	OpenAPI       string                 `yaml:"openapi,omitempty"`
+	Vars          map[string]interface{} `yaml:"vars,omitempty"`
+	Tests         []Case                 `yaml:"tests"`
 }
 
 type Suite struct {
-	Name   string
-	Client Requester
-	File   string
-	Cases  []*Case
+	Name          string
+	Client        Requester
+	File          string
+	Cases         []*Case
+	Fixtures      []Fixture
+	InnerFixtures []InnerFixture
+	// Timeout, if set, is how long all the cases in the suite may take.
+	Timeout time.Duration
+	// OpenAPI, if set, is the document responses must conform to.
+	OpenAPI *OpenAPI
+	// Vars are the initial values of the vars of the cases, available with
+	// $VAR. Each run of the suite starts with them.
+	Vars map[string]interface{}
+}
+
+// SuiteOption configures a Suite created from YAML.
+type SuiteOption func(*Suite)
+
+// WithVars sets vars for the suite, in addition to and taking precedence
+// over those in its YAML.
+func WithVars(vars map[string]interface{}) SuiteOption {
+	return func(s *Suite) {
+		for name, v := range vars {
+			s.Vars[name] = v
+		}
+	}
 }
 
 type MultiSuite struct {
@@ -29,10 +60,33 @@ type MultiSuite struct {
 }
 
 func NewMultiSuiteFromYAMLFiles(t *testing.T, defaultURLBase string, fileNames ...string) (*MultiSuite, error) {
+	return newMultiSuiteFromYAMLFiles(t, defaultURLBase, fileNames, nil)
+}
+
+// NewMultiSuiteFromYAMLFilesWithHandler creates a MultiSuite from the YAML
+// files whose requests are served by handler, in process. Relative URLs
+// are relative to InterceptURLBase.
+func NewMultiSuiteFromYAMLFilesWithHandler(t *testing.T, handler http.Handler, fileNames ...string) (*MultiSuite, error) {
+	return newMultiSuiteFromYAMLFiles(t, InterceptURLBase, fileNames, handler)
+}
+
+// NewMultiSuiteFromYAMLFileList is NewMultiSuiteFromYAMLFiles with opts
+// applied to each Suite.
+func NewMultiSuiteFromYAMLFileList(t *testing.T, defaultURLBase string, fileNames []string, opts ...SuiteOption) (*MultiSuite, error) {
+	return newMultiSuiteFromYAMLFiles(t, defaultURLBase, fileNames, nil, opts...)
+}
+
+// NewMultiSuiteFromYAMLFileListWithHandler is
+// NewMultiSuiteFromYAMLFilesWithHandler with opts applied to each Suite.
+func NewMultiSuiteFromYAMLFileListWithHandler(t *testing.T, handler http.Handler, fileNames []string, opts ...SuiteOption) (*MultiSuite, error) {
+	return newMultiSuiteFromYAMLFiles(t, InterceptURLBase, fileNames, handler, opts...)
+}
+
+func newMultiSuiteFromYAMLFiles(t *testing.T, defaultURLBase string, fileNames []string, handler http.Handler, opts ...SuiteOption) (*MultiSuite, error) {
 	multi := MultiSuite{}
 	multi.Suites = make([]*Suite, len(fileNames))
 	for i, name := range fileNames {
-		suite, err := NewSuiteFromYAMLFile(t, defaultURLBase, name)
+		suite, err := newSuiteFromYAMLFile(t, defaultURLBase, name, handler, opts...)
 		if err != nil {
 			return nil, fmt.Errorf("%w: with file %s", err, name)
 		}
@@ -41,7 +95,21 @@ func NewMultiSuiteFromYAMLFiles(t *testing.T, defaultURLBase string, fileNames .
 	return &multi, nil
 }
 
-func NewSuiteFromYAMLFile(t *testing.T, defaultURLBase, fileName string) (*Suite, error) {
+// NewSuiteFromYAMLFile creates a Suite from the YAML file. Relative URLs
+// are relative to defaultURLBase. t may be nil if the Suite will be run
+// with Run rather than Execute.
+func NewSuiteFromYAMLFile(t *testing.T, defaultURLBase, fileName string, opts ...SuiteOption) (*Suite, error) {
+	return newSuiteFromYAMLFile(t, defaultURLBase, fileName, nil, opts...)
+}
+
+// NewSuiteFromYAMLFileWithHandler creates a Suite from the YAML file whose
+// requests are served by handler, in process. Relative URLs are relative
+// to InterceptURLBase.
+func NewSuiteFromYAMLFileWithHandler(t *testing.T, handler http.Handler, fileName string, opts ...SuiteOption) (*Suite, error) {
+	return newSuiteFromYAMLFile(t, InterceptURLBase, fileName, handler, opts...)
+}
+
+func newSuiteFromYAMLFile(t *testing.T, defaultURLBase, fileName string, handler http.Handler, opts ...SuiteOption) (*Suite, error) {
 	data, err := os.Open(fileName)
 	defer data.Close()
 	if err != nil {
@@ -55,6 +123,15 @@ func NewSuiteFromYAMLFile(t *testing.T, defaultURLBase, fileName string) (*Suite
 		return nil, err
 	}
 
+	suiteFixtures, err := lookupFixtures(sy.Fixtures)
+	if err != nil {
+		return nil, err
+	}
+	suiteInnerFixtures, err := lookupInnerFixtures(sy.InnerFixtures)
+	if err != nil {
+		return nil, err
+	}
+
 	defaultBytes, err := yaml.Marshal(sy.Defaults)
 	if err != nil {
 		return nil, err
@@ -76,38 +153,238 @@ func NewSuiteFromYAMLFile(t *testing.T, defaultURLBase, fileName string) (*Suite
 
 	name := strings.TrimSuffix(path.Base(fileName), path.Ext(fileName))
 
+	var openAPI *OpenAPI
+	if sy.OpenAPI != "" {
+		openAPIFile := sy.OpenAPI
+		if !path.IsAbs(openAPIFile) {
+			openAPIFile = path.Join(path.Dir(fileName), openAPIFile)
+		}
+		openAPI, err = LoadOpenAPI(openAPIFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client := NewClient()
+	if sy.TLS != nil {
+		tlsConfig, err := sy.TLS.Config(fileName)
+		if err != nil {
+			return nil, err
+		}
+		client.SetTLSConfig(tlsConfig)
+	}
+	if sy.CookieJar {
+		client.UseCookieJar()
+	}
+	if handler != nil {
+		client.Intercept(handler)
+	}
+
 	suite := Suite{
-		Name:   name,
-		Cases:  processedCases,
-		Client: NewClient(),
+		Name:          name,
+		File:          fileName,
+		Cases:         processedCases,
+		Client:        client,
+		Fixtures:      suiteFixtures,
+		InnerFixtures: suiteInnerFixtures,
+		Timeout:       time.Duration(sy.Timeout * float64(time.Second)),
+		OpenAPI:       openAPI,
+		Vars:          map[string]interface{}{},
+	}
+	for name, v := range sy.Vars {
+		suite.Vars[name] = v
+	}
+	for _, opt := range opts {
+		opt(&suite)
 	}
 	return &suite, nil
 }
 
-// Execute a single Suite, in series.
+// Replay makes the suite answer requests from cassette instead of sending
+// them, by making its Client a ReplayClient. A BaseClient keeps its
+// settings, such as a cookie jar. Any other Requester is left in place and
+// ErrCassetteUnsupported returned; set Client to a ReplayClient to replace
+// it.
+func (s *Suite) Replay(cassette *Cassette) error {
+	switch client := s.Client.(type) {
+	case *ReplayClient:
+		s.Client = newReplayClient(client.BaseClient, cassette)
+	case *BaseClient:
+		s.Client = newReplayClient(client, cassette)
+	default:
+		return fmt.Errorf("%w: %s: %T", ErrCassetteUnsupported, s.Name, s.Client)
+	}
+	return nil
+}
+
+// RecordTo makes the suite add the requests it sends, and their responses,
+// to cassette. Only a BaseClient can record, with any other Requester
+// ErrCassetteUnsupported is returned.
+func (s *Suite) RecordTo(cassette *Cassette) error {
+	b, ok := s.Client.(*BaseClient)
+	if !ok {
+		return fmt.Errorf("%w: %s: %T", ErrCassetteUnsupported, s.Name, s.Client)
+	}
+	b.RecordTo(cassette)
+	return nil
+}
+
+// Replay makes each suite answer requests from cassette, returning the
+// first error.
+func (m *MultiSuite) Replay(cassette *Cassette) error {
+	for _, s := range m.Suites {
+		if err := s.Replay(cassette); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordTo makes each suite record to cassette, returning the first error.
+func (m *MultiSuite) RecordTo(cassette *Cassette) error {
+	for _, s := range m.Suites {
+		if err := s.RecordTo(cassette); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Execute a single Suite, in series, within its fixtures.
 func (s *Suite) Execute(t *testing.T) {
+	s.Run(NewTestingReporter(t))
+}
+
+// Run a single Suite, in series, within its fixtures, reporting to r.
+func (s *Suite) Run(r Reporter) {
+	stopFixtures, err := s.startFixtures()
+	if err != nil {
+		r.Fatalf("unable to start fixtures for %s: %v", s.Name, err)
+	}
+	defer func() {
+		if err := stopFixtures(); err != nil {
+			r.Errorf("unable to stop fixtures for %s: %v", s.Name, err)
+		}
+	}()
+	ctx, cancel := s.context(r)
+	defer cancel()
+	// Set inner fixtures, context, OpenAPI and vars on every case first,
+	// as a case may run its prior.
+	vars := newSuiteVars(s.Vars)
+	for _, c := range s.Cases {
+		c.SetInnerFixtures(s.InnerFixtures)
+		c.SetContext(ctx)
+		c.SetOpenAPI(s.OpenAPI)
+		c.setVars(vars)
+	}
 	for _, c := range s.Cases {
 		c := c
-		t.Run(c.Name, func(u *testing.T) {
+		r.Run(c.Name, func(u Reporter) {
 			// Reset test reference so nesting works as expected.
-			c.SetTest(u, t)
+			c.SetTest(u, r)
 			s.Client.ExecuteOne(c)
 		})
 	}
 }
 
+// maxDeadlineGrace is the most time left, before the deadline of a
+// Reporter, to report cases which did not finish by then.
+const maxDeadlineGrace = 5 * time.Second
+
+// context returns the context for running the suite, which ends when its
+// Timeout passes or, if r has one, shortly before the deadline of r. A
+// testing.T panics at its deadline, so the suite stops first, with a tenth
+// of the time left, up to maxDeadlineGrace, to report why.
+func (s *Suite) context(r Reporter) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	cancels := []context.CancelFunc{}
+	if d, ok := r.(deadliner); ok {
+		if deadline, ok := d.Deadline(); ok {
+			grace := time.Until(deadline) / 10
+			if grace > maxDeadlineGrace {
+				grace = maxDeadlineGrace
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline.Add(-grace))
+			cancels = append(cancels, cancel)
+		}
+	}
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		cancels = append(cancels, cancel)
+	}
+	return ctx, func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+// ExecuteOption changes how MultiSuite.Execute runs.
+type ExecuteOption func(*executeOptions)
+
+type executeOptions struct {
+	junit io.Writer
+	tap   io.Writer
+}
+
+// WithJUnitReport makes MultiSuite.Execute write a JUnit XML report to w
+// when all the suites are done.
+func WithJUnitReport(w io.Writer) ExecuteOption {
+	return func(o *executeOptions) {
+		o.junit = w
+	}
+}
+
+// WithTAPReport makes MultiSuite.Execute write a TAP report to w when all
+// the suites are done.
+func WithTAPReport(w io.Writer) ExecuteOption {
+	return func(o *executeOptions) {
+		o.tap = w
+	}
+}
+
 // Execute a MultiSuite in parallel.
-func (m *MultiSuite) Execute(t *testing.T) {
+func (m *MultiSuite) Execute(t *testing.T, opts ...ExecuteOption) {
+	options := executeOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.junit == nil && options.tap == nil {
+		m.Run(NewTestingReporter(t))
+		return
+	}
+
+	recorder := NewRecordingReporter(NewTestingReporter(t))
+	// Parallel suites are not done until cleanup.
+	t.Cleanup(func() {
+		if options.junit != nil {
+			if err := recorder.WriteJUnit(options.junit); err != nil {
+				t.Errorf("unable to write junit report: %v", err)
+			}
+		}
+		if options.tap != nil {
+			if err := recorder.WriteTAP(options.tap); err != nil {
+				t.Errorf("unable to write tap report: %v", err)
+			}
+		}
+	})
+	m.Run(recorder)
+}
+
+// Run a MultiSuite, reporting to r. Suites run in parallel if r supports
+// it.
+func (m *MultiSuite) Run(r Reporter) {
 	for _, s := range m.Suites {
 		s := s
-		t.Run(s.Name, func(u *testing.T) {
+		r.Run(s.Name, func(u Reporter) {
 			u.Parallel()
-			s.Execute(u)
+			s.Run(u)
 		})
 	}
 }
 
-// TODO: process for fixtures
 func makeCaseFromYAML(t *testing.T, src Case, defaultBytes []byte, prior *Case) (*Case, error) {
 	newCase := &Case{}
 	err := yaml.Unmarshal(defaultBytes, newCase)
@@ -130,8 +407,14 @@ func makeCaseFromYAML(t *testing.T, src Case, defaultBytes []byte, prior *Case)
 	if err != nil {
 		return newCase, err
 	}
+	err = checkHandlerKeys(newCase)
+	if err != nil {
+		return newCase, fmt.Errorf("%w: in %s", err, newCase.Name)
+	}
 	newCase.SetPrior(prior)
-	newCase.SetTest(t, nil)
+	if t != nil {
+		newCase.SetTest(NewTestingReporter(t), nil)
+	}
 
 	// At this point newCase should now src with any empty values set from
 	// defaults, so now set URL and Method if GET etc are set.
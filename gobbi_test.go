@@ -1,13 +1,33 @@
 package gobbi
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -33,9 +53,147 @@ func init() {
 	for i := range acceptableMethods {
 		acceptableMethodsMap[acceptableMethods[i]] = struct{}{}
 	}
+	RegisterFixture("EnvironFixture", &environFixture{})
+	RegisterInnerFixture("HeaderInnerFixture", &headerInnerFixture{})
+	if err := RegisterResponseHandler("response_body_length", &bodyLengthHandler{}); err != nil {
+		panic(err)
+	}
+	RegisterRequestDataHandler("application/json; gobbi=upper", &upperDataHandler{})
+	reverser := &reverseReplacer{}
+	reverser.regExp = regexp.MustCompile(`\$REVERSE\[(?:"(?P<argD>.+?)"|'(?P<argS>.+?)')\]`)
+	RegisterStringReplacer(reverser)
+}
+
+// bodyLengthHandler checks the length of the response body is the value
+// of response_body_length.
+type bodyLengthHandler struct {
+	BaseResponseHandler
+}
+
+func (b *bodyLengthHandler) Assert(c *Case) {
+	expected, ok := c.GetHandlerData("response_body_length")
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(c.GetResponseBody())
+	if err != nil {
+		c.Fatalf("unable to read body: %v", err)
+	}
+	if expected != len(body) {
+		c.Errorf("expected body length %v, got %d", expected, len(body))
+	}
+}
+
+// upperDataHandler sends the JSON data of a case with its string values
+// upper cased.
+type upperDataHandler struct{}
+
+func (u *upperDataHandler) GetBody(c *Case) (io.Reader, error) {
+	data, ok := c.Data.(map[string]interface{})
+	if !ok {
+		return nil, ErrDataHandlerContentMismatch
+	}
+	upper := map[string]interface{}{}
+	for k, v := range data {
+		if s, ok := v.(string); ok {
+			v = strings.ToUpper(s)
+		}
+		upper[k] = v
+	}
+	body, err := json.Marshal(upper)
+	return strings.NewReader(string(body)), err
+}
+
+// reverseReplacer replaces $REVERSE['value'] with value reversed.
+type reverseReplacer struct {
+	regExp *regexp.Regexp
+}
+
+func (r *reverseReplacer) Replace(c *Case, in string) (string, error) {
+	return ReplaceMatches(r, c, in)
+}
+
+func (r *reverseReplacer) Resolve(prior *Case, argValue, cast string) (string, error) {
+	runes := []rune(argValue)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes), nil
+}
+
+func (r *reverseReplacer) GetRegExp() *regexp.Regexp {
+	return r.regExp
+}
+
+// environFixture sets an environment variable for the duration of a suite.
+type environFixture struct{}
+
+func (e *environFixture) Start(s *Suite) error {
+	return os.Setenv("GOBBI_FIXTURE", s.Name)
+}
+
+func (e *environFixture) Stop(s *Suite) error {
+	return os.Unsetenv("GOBBI_FIXTURE")
+}
+
+// headerInnerFixture sets the http request header, which the test server
+// echoes, and checks it came back.
+type headerInnerFixture struct{}
+
+func (h *headerInnerFixture) Before(c *Case) error {
+	if c.RequestHeaders == nil {
+		c.RequestHeaders = map[string]string{}
+	}
+	c.RequestHeaders["http"] = "inner " + c.Name
+	return nil
+}
+
+func (h *headerInnerFixture) After(c *Case, resp *http.Response) error {
+	if resp == nil {
+		return errors.New("no response")
+	}
+	if resp.Header.Get("http") != "inner "+c.Name {
+		return fmt.Errorf("unexpected http header: %s", resp.Header.Get("http"))
+	}
+	return nil
+}
+
+// recordingFixture records when it is started and stopped, failing to
+// start if failStart is set.
+type recordingFixture struct {
+	name      string
+	record    *[]string
+	failStart bool
+}
+
+func (r *recordingFixture) Start(s *Suite) error {
+	if r.failStart {
+		return errors.New("start failed")
+	}
+	*r.record = append(*r.record, "start "+r.name)
+	return nil
+}
+
+func (r *recordingFixture) Stop(s *Suite) error {
+	*r.record = append(*r.record, "stop "+r.name)
+	return nil
+}
+
+func (r *recordingFixture) Before(c *Case) error {
+	*r.record = append(*r.record, "before "+r.name+" "+c.Name)
+	return nil
+}
+
+func (r *recordingFixture) After(c *Case, resp *http.Response) error {
+	*r.record = append(*r.record, fmt.Sprintf("after %s %s %d", r.name, c.Name, resp.StatusCode))
+	return nil
 }
 
 func GobbiHandler(t *testing.T) http.HandlerFunc {
+	// pollCounters track, by request URI, the remaining requests to
+	// /poller before it succeeds.
+	pollCounters := map[string]int{}
+	pollCounterLock := sync.Mutex{}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if r := recover(); r != nil {
@@ -80,6 +238,42 @@ func GobbiHandler(t *testing.T) http.HandlerFunc {
 			return
 		}
 
+		if strings.HasPrefix(contentType, "multipart/form-data") {
+			// Report the fields and files received.
+			err := r.ParseMultipartForm(1 << 20)
+			if err != nil {
+				t.Logf("unable to parse multipart form in test server: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			files := map[string]interface{}{}
+			for name, headers := range r.MultipartForm.File {
+				fh, err := headers[0].Open()
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				content, _ := io.ReadAll(fh)
+				fh.Close()
+				files[name] = map[string]interface{}{
+					"filename":     headers[0].Filename,
+					"content-type": headers[0].Header.Get("content-type"),
+					"size":         len(content),
+				}
+			}
+			w.Header().Set("content-type", "application/json")
+			encoder := json.NewEncoder(w)
+			err = encoder.Encode(map[string]interface{}{
+				"fields": r.MultipartForm.Value,
+				"files":  files,
+			})
+			if err != nil {
+				t.Logf("unable to encode response body in test server: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+
 		data, _ := io.ReadAll(r.Body)
 
 		if strings.HasPrefix(method, "P") {
@@ -90,6 +284,76 @@ func GobbiHandler(t *testing.T) http.HandlerFunc {
 			}
 		}
 
+		if strings.HasPrefix(pathInfo, "/redirect") {
+			// Redirect hops times before responding, the last time to
+			// the path to if it is set.
+			hops, _ := strconv.Atoi(r.Form.Get("hops"))
+			if hops > 0 {
+				target := *fullRequest
+				target.RawQuery = "hops=" + strconv.Itoa(hops-1)
+				if to := r.Form.Get("to"); to != "" {
+					if hops == 1 {
+						target.Path, target.RawQuery = to, ""
+					} else {
+						target.RawQuery += "&to=" + url.QueryEscape(to)
+					}
+				}
+				w.Header().Set("location", target.String())
+				w.WriteHeader(http.StatusFound)
+				return
+			}
+			w.Write([]byte(`{"redirected": "done"}`))
+			return
+		}
+
+		if strings.HasPrefix(pathInfo, "/poller") {
+			// Fail until count requests have been made, then succeed.
+			pollCounterLock.Lock()
+			defer pollCounterLock.Unlock()
+			key := r.URL.RequestURI()
+			if pollCounters[key] == 0 {
+				count, err := strconv.Atoi(r.Form.Get("count"))
+				if err != nil {
+					count = 5
+				}
+				pollCounters[key] = count
+			}
+			pollCounters[key]--
+			if pollCounters[key] > 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"status": "ACTIVE"}`))
+			return
+		}
+
+		if strings.HasPrefix(pathInfo, "/slow") {
+			// Wait for delay seconds, or until the client goes away.
+			delay, _ := strconv.ParseFloat(r.Form.Get("delay"), 64)
+			select {
+			case <-time.After(time.Duration(delay * float64(time.Second))):
+			case <-r.Context().Done():
+				return
+			}
+			w.Write([]byte(`{"slow": "done"}`))
+			return
+		}
+
+		if strings.HasPrefix(pathInfo, "/cookie") {
+			// Set a cookie for each query parameter and report the
+			// cookies sent.
+			for k := range r.URL.Query() {
+				http.SetCookie(w, &http.Cookie{Name: k, Value: r.URL.Query().Get(k), Path: "/"})
+			}
+			encoder := json.NewEncoder(w)
+			err := encoder.Encode(map[string]string{"cookie": r.Header.Get("cookie")})
+			if err != nil {
+				t.Logf("unable to encode response body in test server: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+
 		if strings.HasPrefix(pathInfo, "/jsonator") {
 			x := map[string]interface{}{}
 			x[urlValues["key"][0]] = urlValues["value"][0]
@@ -124,6 +388,8 @@ func GobbiHandler(t *testing.T) http.HandlerFunc {
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
+		} else if strings.Contains(contentType, "xml") {
+			w.Write(data)
 		} else if len(urlValues) > 0 {
 			encoder := json.NewEncoder(w)
 			err := encoder.Encode(urlValues)
@@ -142,7 +408,7 @@ func TestSimplestRequest(t *testing.T) {
 		URL:    "https://burningchrome.com/",
 		Method: "GET",
 		Status: http.StatusOK,
-		test:   t,
+		test:   NewTestingReporter(t),
 	}
 	client := NewClient()
 
@@ -158,14 +424,14 @@ func TestSimpleSuite(t *testing.T) {
 				URL:    "https://burningchrome.com/",
 				Method: "GET",
 				Status: http.StatusOK,
-				test:   t,
+				test:   NewTestingReporter(t),
 			},
 			&Case{
 				Name:   "simple2",
 				URL:    "https://burningchrome.com/bang",
 				Method: "GET",
 				Status: http.StatusNotFound,
-				test:   t,
+				test:   NewTestingReporter(t),
 			},
 		},
 	}
@@ -225,6 +491,7 @@ func TestAllYAMLWithBase(t *testing.T) {
 
 	os.Setenv("GABBI_TEST_URL", "takingnames")
 	os.Setenv("ONE", "1")
+	os.Setenv("FORBIDDEN_HEADER", "x-gabbi-url")
 
 	multi, err := NewMultiSuiteFromYAMLFiles(t, ts.URL, names...)
 	if err != nil {
@@ -233,6 +500,709 @@ func TestAllYAMLWithBase(t *testing.T) {
 	multi.Execute(t)
 }
 
+func TestFixtureOrder(t *testing.T) {
+	record := []string{}
+	s := &Suite{
+		Name: "fixtures",
+		Fixtures: []Fixture{
+			&recordingFixture{name: "one", record: &record},
+			&recordingFixture{name: "two", record: &record},
+		},
+	}
+	stop, err := s.startFixtures()
+	if err != nil {
+		t.Fatalf("unable to start fixtures: %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Fatalf("unable to stop fixtures: %v", err)
+	}
+	expected := []string{"start one", "start two", "stop two", "stop one"}
+	if !cmp.Equal(expected, record) {
+		t.Errorf("unexpected fixture order: %s", cmp.Diff(expected, record))
+	}
+}
+
+func TestFixtureStartFailure(t *testing.T) {
+	record := []string{}
+	s := &Suite{
+		Name: "fixtures",
+		Fixtures: []Fixture{
+			&recordingFixture{name: "one", record: &record},
+			&recordingFixture{name: "two", record: &record, failStart: true},
+			&recordingFixture{name: "three", record: &record},
+		},
+	}
+	_, err := s.startFixtures()
+	if !errors.Is(err, ErrFixtureFailed) {
+		t.Errorf("expected fixture failure, got %v", err)
+	}
+	expected := []string{"start one", "stop one"}
+	if !cmp.Equal(expected, record) {
+		t.Errorf("unexpected fixture order: %s", cmp.Diff(expected, record))
+	}
+}
+
+func TestInnerFixtures(t *testing.T) {
+	ts := httptest.NewServer(GobbiHandler(t))
+	t.Cleanup(func() { ts.Close() })
+	gcs, err := NewSuiteFromYAMLFile(t, ts.URL, "testdata/last-url.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	gcs.Cases = gcs.Cases[:2]
+	record := []string{}
+	gcs.InnerFixtures = []InnerFixture{
+		&recordingFixture{name: "one", record: &record},
+		&recordingFixture{name: "two", record: &record},
+	}
+	gcs.Execute(t)
+	expected := []string{
+		"before one get a url the first time",
+		"before two get a url the first time",
+		"after two get a url the first time 200",
+		"after one get a url the first time 200",
+		"before one get that same url again",
+		"before two get that same url again",
+		"after two get that same url again 200",
+		"after one get that same url again 200",
+	}
+	if !cmp.Equal(expected, record) {
+		t.Errorf("unexpected inner fixture order: %s", cmp.Diff(expected, record))
+	}
+}
+
+func TestUnknownFixture(t *testing.T) {
+	_, err := NewSuiteFromYAMLFile(t, "", "testdata/fixtures/unknown.yaml")
+	if !errors.Is(err, ErrUnknownFixture) {
+		t.Errorf("expected unknown fixture error, got %v", err)
+	}
+}
+
+func TestUnknownCaseKey(t *testing.T) {
+	_, err := NewSuiteFromYAMLFile(t, "", "testdata/handlers/unknown-key.yaml")
+	if !errors.Is(err, ErrUnknownCaseKey) {
+		t.Errorf("expected unknown case key error, got %v", err)
+	}
+}
+
+// TestJSONSchemaViolations checks every way a response does not match its
+// schema is reported, with where in the response it is.
+func TestJSONSchemaViolations(t *testing.T) {
+	gcs, err := NewSuiteFromYAMLFileWithHandler(nil, GobbiHandler(t), "testdata/json-schema/violations.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	output := &strings.Builder{}
+	gcs.Run(NewTextReporter(output, false))
+	for _, expected := range []string{
+		"FAIL: schema not matched\n",
+		ErrJSONSchemaNotMatched.Error() + ": at /name: length must be >= 1, but got 0\n",
+		ErrJSONSchemaNotMatched.Error() + ": at /age: must be >= 0 but found -1\n",
+		ErrJSONSchemaNotMatched.Error() + ": at /tags/0: expected string, but got number\n",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestRegisterResponseHandlerKey(t *testing.T) {
+	for _, key := range []string{"body_length", "response_", "response_headers"} {
+		err := RegisterResponseHandler(key, &bodyLengthHandler{})
+		if !errors.Is(err, ErrInvalidHandlerKey) {
+			t.Errorf("%s: expected invalid handler key error, got %v", key, err)
+		}
+	}
+}
+
+func TestWithVars(t *testing.T) {
+	gcs, err := NewSuiteFromYAMLFileWithHandler(t, GobbiHandler(t), "testdata/vars/override.yaml",
+		WithVars(map[string]interface{}{"who": "go"}))
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	gcs.Execute(t)
+
+	multi, err := NewMultiSuiteFromYAMLFileListWithHandler(t, GobbiHandler(t), []string{"testdata/vars/override.yaml"},
+		WithVars(map[string]interface{}{"who": "go"}))
+	if err != nil {
+		t.Fatalf("unable to create suites from yamls: %v", err)
+	}
+	multi.Execute(t)
+}
+
+// TestMultipartContentType checks the multipart boundary is sent without
+// changing the request headers of the case.
+func TestMultipartContentType(t *testing.T) {
+	gcs, err := NewSuiteFromYAMLFileWithHandler(t, GobbiHandler(t), "testdata/forms.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	gcs.Execute(t)
+	for _, c := range gcs.Cases {
+		if contentType := c.RequestHeaders["content-type"]; strings.Contains(contentType, "boundary") {
+			t.Errorf("%s: unexpected boundary in case content-type %q", c.Name, contentType)
+		}
+	}
+}
+
+func TestCertValidated(t *testing.T) {
+	ts := httptest.NewTLSServer(GobbiHandler(t))
+	t.Cleanup(func() { ts.Close() })
+	gcs, err := NewSuiteFromYAMLFile(t, ts.URL, "testdata/tls/insecure.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	gcs.Execute(t)
+}
+
+// TestCertValidatedRecording checks unvalidated certificates are allowed
+// while recording to a cassette, and the requests are recorded.
+func TestCertValidatedRecording(t *testing.T) {
+	ts := httptest.NewTLSServer(GobbiHandler(t))
+	t.Cleanup(func() { ts.Close() })
+	gcs, err := NewSuiteFromYAMLFile(t, ts.URL, "testdata/tls/insecure.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	cassette := &Cassette{}
+	if err := gcs.RecordTo(cassette); err != nil {
+		t.Fatal(err)
+	}
+	gcs.Execute(t)
+	if len(cassette.Interactions) != 1 {
+		t.Errorf("expected 1 recorded interaction, got %d", len(cassette.Interactions))
+	}
+}
+
+func TestSuiteTLS(t *testing.T) {
+	clientCert, clientKey := makeClientCertificate(t)
+	ts := httptest.NewUnstartedServer(GobbiHandler(t))
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(clientCert)
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}
+	ts.StartTLS()
+	t.Cleanup(func() { ts.Close() })
+
+	dir := t.TempDir()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	files := map[string][]byte{
+		"ca.pem":         caCert,
+		"client.pem":     clientCert,
+		"client-key.pem": clientKey,
+		"suite.yaml": []byte(`
+tls:
+    ca_file: ca.pem
+    cert_file: client.pem
+    key_file: client-key.pem
+tests:
+- name: validated with ca and client cert
+  GET: /
+`),
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), content, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gcs, err := NewSuiteFromYAMLFile(t, ts.URL, filepath.Join(dir, "suite.yaml"))
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	gcs.Execute(t)
+}
+
+// makeClientCertificate returns a self-signed client certificate and its
+// key, PEM encoded.
+func makeClientCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "gobbi client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// TestSuiteTimeout checks cases fail once the suite timeout has passed.
+func TestSuiteTimeout(t *testing.T) {
+	gcs, err := NewSuiteFromYAMLFileWithHandler(nil, GobbiHandler(t), "testdata/timeout/suite.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	output := &strings.Builder{}
+	reporter := NewTextReporter(output, false)
+	gcs.Run(reporter)
+	for _, expected := range []string{
+		"PASS: first\n",
+		"FAIL: second\n",
+		ErrRequestTimeout.Error() + ": suite deadline passed",
+		"FAIL: third\n",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+
+	// The suite stops before the deadline of its reporter, so it can
+	// report the timeout.
+	gcs, err = NewSuiteFromYAMLFileWithHandler(nil, GobbiHandler(t), "testdata/timeout/deadline.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	output.Reset()
+	deadline := time.Now().Add(2 * time.Second)
+	gcs.Run(&deadlineReporter{Reporter: NewTextReporter(output, false), deadline: deadline})
+	if time.Now().After(deadline) {
+		t.Errorf("suite ran past the deadline of its reporter")
+	}
+	expected := ErrRequestTimeout.Error() + ": suite deadline passed"
+	if !strings.Contains(output.String(), expected) {
+		t.Errorf("expected %q in output:\n%s", expected, output)
+	}
+}
+
+// deadlineReporter is a Reporter with a deadline, as a testing.T may have.
+type deadlineReporter struct {
+	Reporter
+	deadline time.Time
+}
+
+func (d *deadlineReporter) Deadline() (time.Time, bool) {
+	return d.deadline, true
+}
+
+// TestInterceptTimeout checks a case times out when its handler does not
+// return.
+func TestInterceptTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	blocked := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	gcs, err := NewSuiteFromYAMLFileWithHandler(nil, blocked, "testdata/timeout/blocked.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	output := &strings.Builder{}
+	start := time.Now()
+	gcs.Run(NewTextReporter(output, false))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("suite took %s, expected it to stop at the case timeout", elapsed)
+	}
+	expected := ErrRequestTimeout.Error() + ": after 200ms"
+	if !strings.Contains(output.String(), expected) {
+		t.Errorf("expected %q in output:\n%s", expected, output)
+	}
+}
+
+func TestGenerateSuiteYAML(t *testing.T) {
+	spec, err := LoadOpenAPI("testdata/openapi/gobbi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sy := spec.GenerateSuiteYAML()
+	names := []string{}
+	tests := []Case{}
+	for _, c := range sy.Tests {
+		names = append(names, c.Name)
+		// The service does not send the header this requires.
+		if c.GET != "/pets/special" {
+			tests = append(tests, c)
+		}
+	}
+	expectedNames := []string{"echoPet", "GET /cookie", "GET /pets/special", "GET /pets/{id}", "GET /redirect"}
+	if !cmp.Equal(expectedNames, names) {
+		t.Errorf("unexpected cases: %s", cmp.Diff(expectedNames, names))
+	}
+	echo := sy.Tests[0]
+	expectedPaths := map[string]interface{}{"$.name": "Socks", "$.age": 3}
+	if echo.POST != "/" || echo.Status != http.StatusOK || !cmp.Equal(expectedPaths, echo.ResponseJSONPaths) {
+		t.Errorf("unexpected case from example: %#v", echo)
+	}
+
+	// The generated suite conforms to the document it came from.
+	sy.Tests = tests
+	sy.OpenAPI, err = filepath.Abs("testdata/openapi/gobbi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	suiteBytes, err := yaml.Marshal(sy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suiteFile := filepath.Join(t.TempDir(), "generated.yaml")
+	err = os.WriteFile(suiteFile, suiteBytes, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcs, err := NewSuiteFromYAMLFileWithHandler(t, GobbiHandler(t), suiteFile)
+	if err != nil {
+		t.Fatalf("unable to create suite from generated yaml: %v\n%s", err, suiteBytes)
+	}
+	gcs.Execute(t)
+}
+
+// TestCheckResponse checks the problems found with responses which do not
+// conform to the OpenAPI document.
+func TestCheckResponse(t *testing.T) {
+	spec, err := LoadOpenAPI("testdata/openapi/gobbi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		status      int
+		contentType string
+		method      string
+		body        string
+		expected    []string
+	}{
+		{"conforms", http.StatusOK, "application/json", "POST", `{"name": "Socks", "owner": null}`, nil},
+		{"status", http.StatusCreated, "application/json", "POST", `{"name": "Socks"}`,
+			[]string{"POST /: status 201 not declared"}},
+		{"required header", http.StatusOK, "application/json", "", `{"name": "Socks"}`,
+			[]string{"POST /: required header x-gabbi-method missing"}},
+		{"content type", http.StatusOK, "text/plain", "POST", `Socks`,
+			[]string{"POST /: content-type text/plain not declared"}},
+		{"schema", http.StatusOK, "application/json", "POST", `{"name": 5, "owner": 1}`,
+			[]string{
+				"POST /: " + ErrJSONSchemaNotMatched.Error() + ": at /name: expected string, but got number",
+				"POST /: " + ErrJSONSchemaNotMatched.Error() + ": at /owner: expected string or null, but got number",
+			}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Case{Method: http.MethodPost, URL: InterceptURLBase + "/"}
+			header := http.Header{}
+			header.Set("content-type", test.contentType)
+			if test.method != "" {
+				header.Set("x-gabbi-method", test.method)
+			}
+			c.SetResponse(&http.Response{StatusCode: test.status, Header: header})
+			c.SetResponseHeader(header)
+			c.SetResponseBody(strings.NewReader(test.body))
+			problems := []string{}
+			for _, err := range spec.CheckResponse(c) {
+				if !errors.Is(err, ErrOpenAPINotMatched) {
+					t.Errorf("expected %v, got %v", ErrOpenAPINotMatched, err)
+				}
+				problems = append(problems, strings.TrimPrefix(err.Error(), ErrOpenAPINotMatched.Error()+": "))
+			}
+			sort.Strings(problems)
+			if len(problems) == 0 {
+				problems = nil
+			}
+			if !cmp.Equal(test.expected, problems) {
+				t.Errorf("unexpected problems: %s", cmp.Diff(test.expected, problems))
+			}
+		})
+	}
+}
+
+func TestRecorderProxy(t *testing.T) {
+	ts := httptest.NewServer(GobbiHandler(t))
+	t.Cleanup(func() { ts.Close() })
+	recorder := NewRecorder(ts.URL)
+	proxy, err := recorder.Proxy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := httptest.NewServer(proxy)
+	t.Cleanup(func() { ps.Close() })
+
+	requests := []struct {
+		method, path, contentType, body string
+	}{
+		{http.MethodPost, "/pets", "application/json", `{"name": "Socks", "age": 3, "tags": ["cat"]}`},
+		{http.MethodGet, "/redirect?hops=1", "", ""},
+		{http.MethodGet, "/cookie", "", ""},
+	}
+	for _, r := range requests {
+		req, err := http.NewRequest(r.method, ps.URL+r.path, strings.NewReader(r.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.contentType != "" {
+			req.Header.Set("content-type", r.contentType)
+		}
+		req.Header.Set("authorization", "Bearer secret")
+		req.Header.Set("cookie", "flavour=oat")
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	sy := recorder.SuiteYAML()
+	if len(sy.Tests) != len(requests) {
+		t.Fatalf("expected %d cases, got %d", len(requests), len(sy.Tests))
+	}
+	pets := sy.Tests[0]
+	expectedPaths := map[string]interface{}{"$.name": "Socks", "$.age": float64(3)}
+	if pets.POST != "/pets" || pets.RequestHeaders["content-type"] != "application/json" || !cmp.Equal(expectedPaths, pets.ResponseJSONPaths) {
+		t.Errorf("unexpected recorded case: %#v", pets)
+	}
+	if location := sy.Tests[1].ResponseHeaders["location"]; location != "$SCHEME://$NETLOC/redirect?hops=0" {
+		t.Errorf("expected relative location, got %s", location)
+	}
+	expectedHeaders := map[string]string{
+		"authorization": "$ENVIRON['GOBBI_AUTHORIZATION']",
+		"cookie":        "$ENVIRON['GOBBI_COOKIE']",
+	}
+	if headers := sy.Tests[2].RequestHeaders; !cmp.Equal(expectedHeaders, headers) {
+		t.Errorf("unexpected recorded credentials: %s", cmp.Diff(expectedHeaders, headers))
+	}
+
+	// The recorded suite replays against the service, with the credentials
+	// from the environment.
+	t.Setenv("GOBBI_AUTHORIZATION", "Bearer secret")
+	t.Setenv("GOBBI_COOKIE", "flavour=oat")
+	suiteBytes, err := yaml.Marshal(sy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suiteFile := filepath.Join(t.TempDir(), "recorded.yaml")
+	err = os.WriteFile(suiteFile, suiteBytes, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcs, err := NewSuiteFromYAMLFile(t, ts.URL, suiteFile)
+	if err != nil {
+		t.Fatalf("unable to create suite from recorded yaml: %v\n%s", err, suiteBytes)
+	}
+	gcs.Execute(t)
+}
+
+func TestCassette(t *testing.T) {
+	names := []string{
+		"testdata/jsonbody.yaml",
+		"testdata/forms.yaml",
+		"testdata/poll.yaml",
+		"testdata/redirects.yaml",
+		"testdata/xpaths.yaml",
+	}
+	cassette := &Cassette{}
+	multi, err := NewMultiSuiteFromYAMLFilesWithHandler(t, GobbiHandler(t), names...)
+	if err != nil {
+		t.Fatalf("unable to create suites from yamls: %v", err)
+	}
+	if err := multi.RecordTo(cassette); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("record", func(t *testing.T) {
+		multi.Execute(t)
+	})
+	cassetteFile := filepath.Join(t.TempDir(), "cassette.yaml")
+	err = cassette.Save(cassetteFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a handler, requests can only be answered by the cassette.
+	replayed, err := LoadCassette(cassetteFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi, err = NewMultiSuiteFromYAMLFiles(t, InterceptURLBase, names...)
+	if err != nil {
+		t.Fatalf("unable to create suites from yamls: %v", err)
+	}
+	if err := multi.Replay(replayed); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("replay", func(t *testing.T) {
+		multi.Execute(t)
+	})
+}
+
+// offlineRequester is a Requester which cannot reach any service.
+type offlineRequester struct{}
+
+func (offlineRequester) Do(c *Case) {
+	c.Errorf("offline")
+}
+
+func (o offlineRequester) ExecuteOne(c *Case) {
+	o.Do(c)
+}
+
+// TestReplayClient checks a suite with its own Requester is only replayed
+// by choice, with a ReplayClient.
+func TestReplayClient(t *testing.T) {
+	cassette := &Cassette{}
+	gcs, err := NewSuiteFromYAMLFileWithHandler(t, GobbiHandler(t), "testdata/jsonbody.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	if err := gcs.RecordTo(cassette); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("record", func(t *testing.T) {
+		gcs.Execute(t)
+	})
+
+	gcs, err = NewSuiteFromYAMLFile(t, InterceptURLBase, "testdata/jsonbody.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	// A Requester other than a BaseClient is not replaced, unless asked.
+	gcs.Client = offlineRequester{}
+	if err := gcs.Replay(cassette); !errors.Is(err, ErrCassetteUnsupported) {
+		t.Fatalf("expected unsupported cassette error, got %v", err)
+	}
+	if _, ok := gcs.Client.(offlineRequester); !ok {
+		t.Fatalf("expected the Requester to be kept, got %T", gcs.Client)
+	}
+	gcs.Client = NewReplayClient(cassette)
+	t.Run("replay", func(t *testing.T) {
+		gcs.Execute(t)
+	})
+}
+
+func TestCassetteCredentials(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, InterceptURLBase+"/", nil)
+	req.Header.Set("accept", "application/json")
+	req.Header.Set("authorization", "Bearer secret")
+	req.Header.Set("cookie", "flavour=oat")
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	cassette := &Cassette{}
+	cassette.Add(req, nil, resp, nil)
+	expected := map[string]string{"accept": "application/json"}
+	if headers := cassette.Interactions[0].Request.Headers; !cmp.Equal(expected, headers) {
+		t.Errorf("unexpected recorded headers: %s", cmp.Diff(expected, headers))
+	}
+}
+
+func TestCassetteNoInteraction(t *testing.T) {
+	gcs, err := NewSuiteFromYAMLFile(nil, InterceptURLBase, "testdata/jsonbody.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suite from yaml: %v", err)
+	}
+	if err := gcs.Replay(&Cassette{}); err != nil {
+		t.Fatal(err)
+	}
+	output := &strings.Builder{}
+	gcs.Run(NewTextReporter(output, false))
+	if !strings.Contains(output.String(), ErrNoCassetteInteraction.Error()) {
+		t.Errorf("expected %q in output:\n%s", ErrNoCassetteInteraction, output)
+	}
+}
+
+// TestAllYAMLWithHandler tests every yaml file in the testdata directory
+// without a listener.
+func TestAllYAMLWithHandler(t *testing.T) {
+	files, err := os.ReadDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".yaml") {
+			continue
+		}
+		names = append(names, "testdata/"+f.Name())
+	}
+
+	os.Setenv("GABBI_TEST_URL", "takingnames")
+	os.Setenv("ONE", "1")
+	os.Setenv("FORBIDDEN_HEADER", "x-gabbi-url")
+
+	multi, err := NewMultiSuiteFromYAMLFilesWithHandler(t, GobbiHandler(t), names...)
+	if err != nil {
+		t.Fatalf("unable to create suites from yamls: %v", err)
+	}
+	multi.Execute(t)
+}
+
+func TestTextReporter(t *testing.T) {
+	multi, err := NewMultiSuiteFromYAMLFilesWithHandler(nil, GobbiHandler(t), "testdata/skip.yaml", "testdata/self.yaml")
+	if err != nil {
+		t.Fatalf("unable to create suites from yamls: %v", err)
+	}
+	os.Setenv("GABBI_TEST_URL", "takingnames")
+	output := &strings.Builder{}
+	reporter := NewTextReporter(output, false)
+	multi.Run(reporter)
+	if reporter.Failed() {
+		t.Errorf("expected no failures: %s", output)
+	}
+	for _, expected := range []string{
+		"SKIP: skip/enable skip\n",
+		"PASS: self/get simple page\n",
+		"XFAIL: self/test seeing panic\n",
+		"XFAIL: self/xml derived content type\n",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("expected %q in output:\n%s", expected, output)
+		}
+	}
+}
+
+func TestExecuteReports(t *testing.T) {
+	os.Setenv("GABBI_TEST_URL", "takingnames")
+	junit := &strings.Builder{}
+	tap := &strings.Builder{}
+	// Reports are written on cleanup, so run in a subtest.
+	t.Run("execute", func(u *testing.T) {
+		multi, err := NewMultiSuiteFromYAMLFilesWithHandler(u, GobbiHandler(u), "testdata/skip.yaml", "testdata/self.yaml")
+		if err != nil {
+			u.Fatalf("unable to create suites from yamls: %v", err)
+		}
+		multi.Execute(u, WithJUnitReport(junit), WithTAPReport(tap))
+	})
+
+	// Named cases are checked, rather than counts or positions, so cases
+	// may be added to the suites.
+	for _, expected := range []string{
+		`<testsuite name="skip" tests="\d+" failures="0" errors="0" skipped="\d+"`,
+		`<testsuite name="self" tests="\d+" failures="0" errors="0" skipped="\d+"`,
+		`<testcase name="get simple page" classname="self" time="[\d.]+">\s*<system-out>[^<]*</system-out>\s*</testcase>`,
+		`<testcase name="enable skip" classname="skip" time="[\d.]+">\s*<skipped message="&lt;enable skip&gt; skipping: not today">`,
+		`<testcase name="test seeing panic" classname="self" time="[\d.]+">\s*<skipped message="expected failure: [^"]*" type="xfail">`,
+	} {
+		if !regexp.MustCompile(expected).MatchString(junit.String()) {
+			t.Errorf("expected %q in junit:\n%s", expected, junit)
+		}
+	}
+	for _, expected := range []string{
+		`^TAP version 13\n1\.\.\d+\n`,
+		`(?m)^ok \d+ - skip/enable skip # SKIP <enable skip> skipping: not today$`,
+		`(?m)^ok \d+ - self/get simple page$`,
+		`(?m)^not ok \d+ - self/test seeing panic # TODO expected failure: `,
+	} {
+		if !regexp.MustCompile(expected).MatchString(tap.String()) {
+			t.Errorf("expected %q in tap:\n%s", expected, tap)
+		}
+	}
+}
+
 func TestResponseRegexpDoubleQuote(t *testing.T) {
 	matches := responseRegexp.FindAllStringSubmatch(`$RESPONSE["$.foo.bar"]`, -1)
 	argIndex := responseRegexp.SubexpIndex("argD")
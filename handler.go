@@ -3,8 +3,12 @@ package gobbi
 import (
 	"fmt"
 	"io"
+	"math"
+	"net/http"
 	"os"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -15,6 +19,8 @@ const (
 	environRegexpString  = `\$ENVIRON(:(?P<cast>\w+))?\[(?:\\?"(?P<argD>.+?)\\?"|'(?P<argS>.+?)')\]`
 	locationRegexpString = `\$LOCATION`
 	urlRegexpString      = `\$URL`
+	cookieRegexpString   = `\$COOKIE\b`
+	xpathRegexpString    = `\$XPATH(:(?P<cast>\w+))?\[(?:\\?"(?P<argD>.+?)\\?"|'(?P<argS>.+?)')\]`
 )
 
 var (
@@ -23,9 +29,21 @@ var (
 	headersRegexp    *regexp.Regexp
 	environRegexp    *regexp.Regexp
 	urlRegexp        *regexp.Regexp
+	cookieRegexp     *regexp.Regexp
+	xpathRegexp      *regexp.Regexp
+	varRegexp        *regexp.Regexp
 	stringReplacers  []StringReplacer
 	responseHandlers []ResponseHandler
 	requestHandlers  map[string]RequestDataHandler
+	// templateReplacers is how many template functions end
+	// stringReplacers.
+	templateReplacers int
+	// responseHandlerKeys maps the case keys of registered response
+	// handlers to their index in responseHandlers.
+	responseHandlerKeys = map[string]int{}
+	// contentTypeHandlers maps request content-type prefixes to the
+	// handlers for data of that type.
+	contentTypeHandlers = map[string]RequestDataHandler{}
 )
 
 func init() {
@@ -34,6 +52,9 @@ func init() {
 	headersRegexp = regexp.MustCompile(historyRegexpString + headersRegexpString)
 	environRegexp = regexp.MustCompile(environRegexpString)
 	urlRegexp = regexp.MustCompile(historyRegexpString + urlRegexpString)
+	cookieRegexp = regexp.MustCompile(historyRegexpString + cookieRegexpString)
+	xpathRegexp = regexp.MustCompile(historyRegexpString + xpathRegexpString)
+	varRegexp = regexp.MustCompile(varRegexpString)
 	lr := &LocationReplacer{}
 	lr.regExp = locationRegexp
 	hr := &HeadersReplacer{}
@@ -44,6 +65,12 @@ func init() {
 	jr.regExp = responseRegexp
 	ur := &URLReplacer{}
 	ur.regExp = urlRegexp
+	cr := &CookieReplacer{}
+	cr.regExp = cookieRegexp
+	xr := &XMLHandler{}
+	xr.regExp = xpathRegexp
+	vr := &VarReplacer{}
+	vr.regExp = varRegexp
 	sr := &SchemeReplacer{}
 	nr := &NetlocReplacer{}
 	lu := &LastURLReplacer{}
@@ -53,21 +80,119 @@ func init() {
 		lu,
 		ur,
 		lr,
+		cr,
 		hr,
 		er,
+		vr,
 		jr,
+		xr,
 	}
+	templates := newTemplateReplacers()
+	stringReplacers = append(stringReplacers, templates...)
+	templateReplacers = len(templates)
 	responseHandlers = []ResponseHandler{
 		&StringResponseHandler{},
 		jr,
+		&JSONSchemaHandler{},
+		xr,
 		&HeaderResponseHandler{},
+		&ForbiddenHeaderResponseHandler{},
+		&OpenAPIResponseHandler{},
 	}
 	requestHandlers = map[string]RequestDataHandler{
-		"text":   &TextDataHandler{},
-		"json":   jr,
-		"nil":    &NilDataHandler{},
-		"binary": &BinaryDataHandler{},
+		"text":      &TextDataHandler{},
+		"json":      jr,
+		"nil":       &NilDataHandler{},
+		"binary":    &BinaryDataHandler{},
+		"form":      &FormDataHandler{},
+		"multipart": &MultipartDataHandler{},
 	}
+	RegisterRequestDataHandler("application/json", requestHandlers["json"])
+	RegisterRequestDataHandler("text/plain", requestHandlers["text"])
+	RegisterRequestDataHandler("application/x-www-form-urlencoded", requestHandlers["form"])
+	RegisterRequestDataHandler("multipart/form-data", requestHandlers["multipart"])
+}
+
+// responseHandlerKeyPrefix starts the keys of registered response handlers.
+const responseHandlerKeyPrefix = "response_"
+
+// RegisterResponseHandler adds h to the handlers asserting every response,
+// making key, which must start with response_ and not be a built in key, a
+// valid key in cases. The value of key in a case is available to h from
+// Case.GetHandlerData. Registering a key again replaces its handler. It
+// should be called before suites are created, for example in init or
+// TestMain.
+func RegisterResponseHandler(key string, h ResponseHandler) error {
+	if !strings.HasPrefix(key, responseHandlerKeyPrefix) || key == responseHandlerKeyPrefix {
+		return fmt.Errorf("%w: %s: must start with %s", ErrInvalidHandlerKey, key, responseHandlerKeyPrefix)
+	}
+	if isCaseField(key) {
+		return fmt.Errorf("%w: %s: is a built in key", ErrInvalidHandlerKey, key)
+	}
+	if i, ok := responseHandlerKeys[key]; ok {
+		responseHandlers[i] = h
+		return nil
+	}
+	responseHandlerKeys[key] = len(responseHandlers)
+	responseHandlers = append(responseHandlers, h)
+	return nil
+}
+
+// isCaseField reports whether key is the YAML key of a field of Case.
+func isCaseField(key string) bool {
+	caseType := reflect.TypeOf(Case{})
+	for i := 0; i < caseType.NumField(); i++ {
+		name, _, _ := strings.Cut(caseType.Field(i).Tag.Get("yaml"), ",")
+		if name == key {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterRequestDataHandler makes h provide the request body for cases
+// with a content-type starting with contentType. The longest matching
+// contentType is used. It should be called before suites are run.
+func RegisterRequestDataHandler(contentType string, h RequestDataHandler) {
+	contentTypeHandlers[strings.ToLower(contentType)] = h
+}
+
+// RegisterStringReplacer adds r to the replacers used on URLs, headers,
+// data and expected values. It runs after the built in replacers, except
+// template functions, so their arguments may contain its substitutions. It
+// should be called before suites are run.
+func RegisterStringReplacer(r StringReplacer) {
+	at := len(stringReplacers) - templateReplacers
+	replacers := make([]StringReplacer, 0, len(stringReplacers)+1)
+	replacers = append(replacers, stringReplacers[:at]...)
+	replacers = append(replacers, r)
+	stringReplacers = append(replacers, stringReplacers[at:]...)
+}
+
+// lookupRequestDataHandler returns the registered RequestDataHandler for
+// contentType, or nil if there is none.
+func lookupRequestDataHandler(contentType string) RequestDataHandler {
+	contentType = strings.ToLower(contentType)
+	var found RequestDataHandler
+	longest := -1
+	for prefix, h := range contentTypeHandlers {
+		if strings.HasPrefix(contentType, prefix) && len(prefix) > longest {
+			found = h
+			longest = len(prefix)
+		}
+	}
+	return found
+}
+
+// checkHandlerKeys returns an error if c has keys which are not those of
+// registered response handlers.
+func checkHandlerKeys(c *Case) error {
+	for key := range c.HandlerData {
+		if _, ok := responseHandlerKeys[key]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownCaseKey, key)
+		}
+	}
+	return nil
 }
 
 type StringReplacer interface {
@@ -76,6 +201,14 @@ type StringReplacer interface {
 	GetRegExp() *regexp.Regexp
 }
 
+// A ValueResolver is a StringReplacer which can resolve to a value that is
+// not a string, such as a number or object from a JSON response. It is used
+// when a substitution is the whole of a value in structured data.
+type ValueResolver interface {
+	StringReplacer
+	ResolveValue(*Case, string) (interface{}, error)
+}
+
 type BaseStringReplacer struct {
 	regExp *regexp.Regexp
 }
@@ -124,47 +257,102 @@ type URLReplacer struct {
 	BaseStringReplacer
 }
 
-func baseReplace(rpl StringReplacer, c *Case, in string) (string, error) {
-	regExp := rpl.GetRegExp()
-	matches := regExp.FindAllStringSubmatch(in, -1)
-	if len(matches) == 0 {
-		return in, nil
-	}
-	replacements := make([]string, len(matches))
+type CookieReplacer struct {
+	BaseStringReplacer
+}
 
+// currentCaseResolver is a StringReplacer whose values, when no case is
+// named with $HISTORY, come from the case being run rather than its prior.
+type currentCaseResolver interface {
+	resolvesCurrentCase()
+}
+
+// parseMatch finds the prior case, argument and cast, if any, in one
+// match of the regular expression of rpl. If the expression has no case
+// groups, or rpl is a currentCaseResolver and no case is named, the case
+// is c.
+func parseMatch(rpl StringReplacer, c *Case, match []string) (*Case, string, string, error) {
+	regExp := rpl.GetRegExp()
 	caseDIndex := regExp.SubexpIndex("caseD")
 	caseSIndex := regExp.SubexpIndex("caseS")
 	argDIndex := regExp.SubexpIndex("argD")
 	argSIndex := regExp.SubexpIndex("argS")
+	argUIndex := regExp.SubexpIndex("argU")
 	castIndex := regExp.SubexpIndex("cast")
 
-	for i := range matches {
-		var prior *Case
-		var argValue string
-		var cast string
-		if caseDIndex >= 0 && caseSIndex >= 0 {
-			caseName := matches[i][caseDIndex]
-			if len(caseName) == 0 {
-				caseName = matches[i][caseSIndex]
-			}
+	// Without a case in the expression, values come from c itself.
+	prior := c
+	var argValue string
+	var cast string
+	if caseDIndex >= 0 && caseSIndex >= 0 {
+		caseName := match[caseDIndex]
+		if len(caseName) == 0 {
+			caseName = match[caseSIndex]
+		}
+		_, current := rpl.(currentCaseResolver)
+		if caseName != "" || !current {
 			prior = c.GetPrior(caseName)
-			if prior == nil {
-				return "", ErrNoPriorTest
-			}
 		}
-		if argDIndex >= 0 && argSIndex >= 0 {
-			argValue = matches[i][argDIndex]
-			if len(argValue) == 0 {
-				argValue = matches[i][argSIndex]
-			}
+		if prior == nil {
+			return nil, "", "", ErrNoPriorTest
 		}
-		if castIndex >= 0 {
-			cast = matches[i][castIndex]
+	}
+	if argDIndex >= 0 && argSIndex >= 0 {
+		argValue = match[argDIndex]
+		if len(argValue) == 0 {
+			argValue = match[argSIndex]
 		}
-		rValue, err := rpl.Resolve(prior, argValue, cast)
+	}
+	if argUIndex >= 0 && len(argValue) == 0 {
+		argValue = match[argUIndex]
+	}
+	if castIndex >= 0 {
+		cast = match[castIndex]
+	}
+	return prior, argValue, cast, nil
+}
+
+// resolveMatch resolves one match of the regular expression of rpl,
+// returning the resolved value and the cast, if any, to apply to it.
+func resolveMatch(rpl StringReplacer, c *Case, match []string) (string, string, error) {
+	prior, argValue, cast, err := parseMatch(rpl, c, match)
+	if err != nil {
+		return "", "", err
+	}
+	rValue, err := rpl.Resolve(prior, argValue, cast)
+	return rValue, cast, err
+}
+
+// ReplaceMatches replaces each match of the regular expression of rpl in
+// in with the value from its Resolve. It is how a StringReplacer can
+// implement Replace, given a regular expression using the named groups
+// caseD or caseS for the name of a case in $HISTORY, argD or argS for an
+// argument in double or single quotes, argU for one without quotes, and
+// cast for a cast. Without case groups, Resolve is given c.
+func ReplaceMatches(rpl StringReplacer, c *Case, in string) (string, error) {
+	return baseReplace(rpl, c, in)
+}
+
+func baseReplace(rpl StringReplacer, c *Case, in string) (string, error) {
+	regExp := rpl.GetRegExp()
+	matches := regExp.FindAllStringSubmatch(in, -1)
+	if len(matches) == 0 {
+		return in, nil
+	}
+	replacements := make([]string, len(matches))
+
+	for i := range matches {
+		rValue, cast, err := resolveMatch(rpl, c, matches[i])
 		if err != nil {
 			return "", err
 		}
+		if cast != "" {
+			typed, err := castValue(rValue, cast)
+			if err != nil {
+				return "", err
+			}
+			rValue = scalarToString(typed)
+		}
 		replacements[i] = rValue
 	}
 
@@ -173,6 +361,43 @@ func baseReplace(rpl StringReplacer, c *Case, in string) (string, error) {
 	return in, nil
 }
 
+// castValue converts a resolved value to the type named by cast, one of
+// int, float, bool or str as in gabbi.
+func castValue(value, cast string) (interface{}, error) {
+	switch cast {
+	case "str":
+		return value, nil
+	case "int":
+		i, err := strconv.Atoi(value)
+		if err == nil {
+			return i, nil
+		}
+		// Numbers from JSON may be written as floats, but only whole
+		// ones are ints.
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s to %s: %v", ErrCastFailed, value, cast, err)
+		}
+		if f != math.Trunc(f) {
+			return nil, fmt.Errorf("%w: %s to %s: not a whole number", ErrCastFailed, value, cast)
+		}
+		return int(f), nil
+	case "float":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s to %s: %v", ErrCastFailed, value, cast, err)
+		}
+		return f, nil
+	case "bool":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s to %s: %v", ErrCastFailed, value, cast, err)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCast, cast)
+}
+
 func (s *SchemeReplacer) Replace(c *Case, in string) (string, error) {
 	return strings.ReplaceAll(in, "$SCHEME", c.ParsedURL().Scheme), nil
 }
@@ -198,13 +423,28 @@ func (l *LocationReplacer) Replace(c *Case, in string) (string, error) {
 }
 
 func (u *URLReplacer) Resolve(prior *Case, argValue, cast string) (string, error) {
-	return prior.URL, nil
+	return prior.GetFinalURL(), nil
 }
 
 func (u *URLReplacer) Replace(c *Case, in string) (string, error) {
 	return baseReplace(u, c, in)
 }
 
+// Resolve returns the cookies set by the response to prior in the form of
+// the value of a Cookie request header.
+func (cr *CookieReplacer) Resolve(prior *Case, argValue, cast string) (string, error) {
+	resp := http.Response{Header: prior.GetResponseHeader()}
+	cookies := []string{}
+	for _, cookie := range resp.Cookies() {
+		cookies = append(cookies, (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String())
+	}
+	return strings.Join(cookies, "; "), nil
+}
+
+func (cr *CookieReplacer) Replace(c *Case, in string) (string, error) {
+	return baseReplace(cr, c, in)
+}
+
 func (e *EnvironReplacer) Resolve(prior *Case, argValue, cast string) (string, error) {
 	if value, ok := os.LookupEnv(argValue); !ok {
 		return "", fmt.Errorf("%w: %s", ErrEnvironmentVariableNotFound, argValue)
@@ -237,6 +477,93 @@ func StringReplace(c *Case, in string) (string, error) {
 
 }
 
+// StringReplaceValue does StringReplace for a value in structured data. If
+// the whole of in is one substitution the result keeps its type: that of
+// the cast, if there is one, or otherwise that of the resolved value, so a
+// number or object from a JSON response stays a number or object.
+func StringReplaceValue(c *Case, in string) (interface{}, error) {
+	for _, replacer := range stringReplacers {
+		regExp := replacer.GetRegExp()
+		if regExp == nil {
+			continue
+		}
+		match := regExp.FindStringSubmatch(in)
+		if match == nil || match[0] != in {
+			continue
+		}
+		prior, argValue, cast, err := parseMatch(replacer, c, match)
+		if err != nil {
+			return nil, err
+		}
+		if cast == "" {
+			if vr, ok := replacer.(ValueResolver); ok {
+				return vr.ResolveValue(prior, argValue)
+			}
+			break
+		}
+		value, err := replacer.Resolve(prior, argValue, cast)
+		if err != nil {
+			return nil, err
+		}
+		return castValue(value, cast)
+	}
+	return StringReplace(c, in)
+}
+
+// replaceData does StringReplace on the keys, and StringReplaceValue on
+// the string values, of data decoded from YAML or JSON.
+func replaceData(c *Case, data interface{}) (interface{}, error) {
+	switch x := data.(type) {
+	case string:
+		return StringReplaceValue(c, x)
+	case map[string]interface{}:
+		replaced := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			newK, err := StringReplace(c, k)
+			if err != nil {
+				return nil, err
+			}
+			newV, err := replaceData(c, v)
+			if err != nil {
+				return nil, err
+			}
+			replaced[newK] = newV
+		}
+		return replaced, nil
+	case []interface{}:
+		replaced := make([]interface{}, len(x))
+		for i, v := range x {
+			newV, err := replaceData(c, v)
+			if err != nil {
+				return nil, err
+			}
+			replaced[i] = newV
+		}
+		return replaced, nil
+	}
+	return data, nil
+}
+
+// isRegexp reports whether an expected value is written, as in gabbi, as
+// a regular expression between slashes.
+func isRegexp(expected string) bool {
+	return len(expected) > 1 && strings.HasPrefix(expected, "/") && strings.HasSuffix(expected, "/")
+}
+
+// matchRegexp returns an error if actual does not match expected, a
+// regular expression between slashes.
+func matchRegexp(expected, actual string) error {
+	pattern := expected[1 : len(expected)-1]
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidRegexp, expected, err)
+	}
+	if !re.MatchString(actual) {
+		return fmt.Errorf("%w: pattern %s, actual %s", ErrRegexpNotMatched, expected, actual)
+	}
+	return nil
+}
+
 type RequestDataHandler interface {
 	GetBody(c *Case) (io.Reader, error)
 }
@@ -315,7 +642,12 @@ func (h *HeaderResponseHandler) Assert(c *Case) {
 			c.Errorf("unable to replace response header value: %s, %v", v, err)
 			headerValue = v
 		}
-		if hv != headerValue {
+		if isRegexp(headerValue) {
+			err := matchRegexp(headerValue, hv)
+			if err != nil {
+				c.Errorf("For header %s: %v", headerName, err)
+			}
+		} else if hv != headerValue {
 			c.Errorf("For header %s expecting value %s, got %s", headerName, headerValue, hv)
 		}
 	}
@@ -327,6 +659,40 @@ func (h *HeaderResponseHandler) Replacer(c *Case, v string) string {
 	return result
 }
 
+type ForbiddenHeaderResponseHandler struct {
+	BaseResponseHandler
+}
+
+func (f *ForbiddenHeaderResponseHandler) Assert(c *Case) {
+	if len(c.ResponseForbiddenHeaders) == 0 {
+		return
+	}
+
+	if !f.Accepts(c) {
+		return
+	}
+
+	for _, h := range c.ResponseForbiddenHeaders {
+		err := f.ProcessOneHeader(c, h)
+		if err != nil {
+			c.Errorf("%v", err)
+		}
+	}
+}
+
+// ProcessOneHeader returns an error if the header named h, after string
+// replacement, is present in the response.
+func (f *ForbiddenHeaderResponseHandler) ProcessOneHeader(c *Case, h string) error {
+	headerName, err := StringReplace(c, h)
+	if err != nil {
+		return fmt.Errorf("unable to replace forbidden header name: %s, %w", h, err)
+	}
+	if values := c.GetResponseHeader().Values(headerName); len(values) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrForbiddenHeaderPresent, headerName, strings.Join(values, ", "))
+	}
+	return nil
+}
+
 type StringResponseHandler struct {
 	BaseResponseHandler
 }
@@ -355,7 +721,12 @@ func (s *StringResponseHandler) Assert(c *Case) {
 		if err != nil {
 			c.Errorf("unable to process response string check: %s", check)
 		}
-		if !strings.Contains(stringBody, check) {
+		if isRegexp(check) {
+			err := matchRegexp(check, stringBody)
+			if err != nil {
+				c.Errorf("%v in body: %s", err, stringBody[:limit])
+			}
+		} else if !strings.Contains(stringBody, check) {
 			c.Errorf("<%s> not in body: %s", check, stringBody[:limit])
 		}
 	}
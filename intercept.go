@@ -0,0 +1,106 @@
+package gobbi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+const (
+	// InterceptURLBase is the default URL base for suites that send their
+	// requests to an http.Handler rather than over the network. $SCHEME
+	// and $NETLOC resolve from it.
+	InterceptURLBase = "http://gobbi.test"
+)
+
+var (
+	ErrHandlerPanic = fmt.Errorf("%w: handler panicked", ErrTestError)
+)
+
+// handlerTransport is an http.RoundTripper which serves requests to host
+// by calling handler in process. Requests to other hosts are passed to
+// next.
+type handlerTransport struct {
+	handler http.Handler
+	host    string
+	next    http.RoundTripper
+}
+
+func (h *handlerTransport) RoundTrip(rq *http.Request) (*http.Response, error) {
+	if rq.URL.Host != h.host {
+		return h.next.RoundTrip(rq)
+	}
+
+	// Make the request look like one received by a server.
+	serverRequest := rq.Clone(rq.Context())
+	serverRequest.RequestURI = rq.URL.RequestURI()
+	var err error
+	serverRequest.URL, err = url.ParseRequestURI(serverRequest.RequestURI)
+	if err != nil {
+		return nil, err
+	}
+	if serverRequest.Host == "" {
+		serverRequest.Host = rq.URL.Host
+	}
+	serverRequest.RemoteAddr = "192.0.2.1:1234"
+	if rq.URL.Scheme == "https" {
+		serverRequest.TLS = &tls.ConnectionState{
+			Version:           tls.VersionTLS12,
+			HandshakeComplete: true,
+			ServerName:        rq.URL.Hostname(),
+		}
+	}
+	if serverRequest.Body == nil {
+		serverRequest.Body = http.NoBody
+	}
+
+	// The handler runs apart from the client so that, as over the network,
+	// a request which ends while being handled, as when its timeout
+	// passes, has no response even if the handler never returns.
+	done := make(chan error, 1)
+	recorder := httptest.NewRecorder()
+	go func() {
+		// A server would drop the connection when the handler panics,
+		// which the client sees as an error.
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+			}
+		}()
+		h.handler.ServeHTTP(recorder, serverRequest)
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+		if err := rq.Context().Err(); err != nil {
+			return nil, err
+		}
+	case <-rq.Context().Done():
+		return nil, rq.Context().Err()
+	}
+	resp := recorder.Result()
+	resp.Request = rq
+	return resp, nil
+}
+
+// Intercept makes requests to the host of InterceptURLBase be served by
+// handler, in process, without a listener.
+func (b *BaseClient) Intercept(handler http.Handler) {
+	next := b.Client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	// Ignore the error because the constant is a valid url.
+	u, _ := url.Parse(InterceptURLBase)
+	b.Client.Transport = &handlerTransport{
+		handler: handler,
+		host:    u.Host,
+		next:    next,
+	}
+	b.insecureClient = nil
+}
@@ -1,6 +1,7 @@
 package gobbi
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -38,20 +39,10 @@ type JSONHandler struct {
 }
 
 func (j *JSONHandler) Resolve(prior *Case, argValue, cast string) (string, error) {
-	jpr := &JSONHandler{}
-	_, err := prior.GetResponseBody().Seek(0, io.SeekStart)
-	if err != nil {
-		return "", err
-	}
-	rawJSON, err := jpr.ReadJSONReponse(prior)
+	output, err := j.ResolveValue(prior, argValue)
 	if err != nil {
 		return "", err
 	}
-	o, err := jsonpath.Retrieve(argValue, rawJSON, jsonPathConfig)
-	if err != nil {
-		return "", err
-	}
-	output := deList(o)
 	switch x := output.(type) {
 	case string:
 		return x, nil
@@ -61,6 +52,25 @@ func (j *JSONHandler) Resolve(prior *Case, argValue, cast string) (string, error
 	}
 }
 
+// ResolveValue returns the value at the JSON path argValue in the response
+// to prior, as decoded from JSON.
+func (j *JSONHandler) ResolveValue(prior *Case, argValue string) (interface{}, error) {
+	jpr := &JSONHandler{}
+	_, err := prior.GetResponseBody().Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	rawJSON, err := jpr.ReadJSONReponse(prior)
+	if err != nil {
+		return nil, err
+	}
+	o, err := jsonpath.Retrieve(argValue, rawJSON, jsonPathConfig)
+	if err != nil {
+		return nil, err
+	}
+	return deList(o), nil
+}
+
 func (j *JSONHandler) Replace(c *Case, in string) (string, error) {
 	return baseReplace(j, c, in)
 }
@@ -113,12 +123,15 @@ func (j *JSONHandler) GetBody(c *Case) (io.Reader, error) {
 		}
 		return strings.NewReader(stringData), nil
 	}
-	data, err := json.Marshal(c.Data)
+	replaced, err := replaceData(c, c.Data)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(replaced)
 	if err != nil {
 		return nil, err
 	}
-	dataString, err := j.Replace(c, string(data))
-	return strings.NewReader(dataString), err
+	return bytes.NewReader(data), nil
 }
 
 func deList(i any) any {
@@ -154,22 +167,13 @@ func (j *JSONHandler) Assert(c *Case) {
 		c.Fatalf("Unable to read JSON from body: %v", err)
 	}
 
-	// Dump ResponseJSONPaths to JSON, make it a string, do StringReplace,
-	// assign it back.
-	pathData, err := json.Marshal(c.ResponseJSONPaths)
-	if err != nil {
-		c.Fatalf("Unable to process JSON Paths: %v", err)
-	}
-	processedData, err := StringReplace(c, string(pathData))
-	if err != nil {
-		c.Fatalf("Unable to string replace JSON Paths %s: %v", pathData, err)
-	}
-	err = json.Unmarshal([]byte(processedData), &c.ResponseJSONPaths)
+	// Replace in both the paths and the expected values.
+	processedData, err := replaceData(c, c.ResponseJSONPaths)
 	if err != nil {
-		c.Fatalf("Unable to unmarshal JSON Paths: %v", err)
+		c.Fatalf("Unable to string replace JSON Paths: %v", err)
 	}
 
-	for path, v := range c.ResponseJSONPaths {
+	for path, v := range processedData.(map[string]interface{}) {
 		err := j.ProcessOnePath(c, rawJSON, path, v)
 		if err != nil {
 			c.Errorf("%v", err)
@@ -218,17 +222,42 @@ func (j *JSONHandler) ProcessOnePath(c *Case, rawJSON interface{}, path string,
 		return err
 	}
 	output := deList(o)
-	// This switch works around numerals in JSON being weird and that it
-	// is proving difficult to get a cmp.Transformer to work as expected.
-	switch value := v.(type) {
-	case int:
-		if !cmp.Equal(float64(value), output) {
-			return fmt.Errorf("%w: diff: %s", ErrJSONPathNotMatched, cmp.Diff(float64(value), output))
+	if stringData, ok := v.(string); ok && isRegexp(stringData) {
+		actual, ok := output.(string)
+		if !ok {
+			rawActual, err := json.Marshal(output)
+			if err != nil {
+				return err
+			}
+			actual = string(rawActual)
 		}
-	default:
-		if !cmp.Equal(value, output) {
-			return fmt.Errorf("%w: diff: %s", ErrJSONPathNotMatched, cmp.Diff(value, output))
+		err := matchRegexp(stringData, actual)
+		if err != nil {
+			return fmt.Errorf("%w: for path %s", err, path)
 		}
+		return nil
+	}
+	// Round trip the expected value through JSON to work around numerals
+	// in JSON being weird, at any depth, as it is proving difficult to get
+	// a cmp.Transformer to work as expected.
+	value, err := normalizeJSON(v)
+	if err != nil {
+		return err
+	}
+	if !cmp.Equal(value, output) {
+		return fmt.Errorf("%w: diff: %s", ErrJSONPathNotMatched, cmp.Diff(value, output))
 	}
 	return nil
 }
+
+// normalizeJSON returns v as it would be if decoded from JSON, so that, for
+// example, integers from YAML become float64.
+func normalizeJSON(v interface{}) (interface{}, error) {
+	rawBytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var normal interface{}
+	err = json.Unmarshal(rawBytes, &normal)
+	return normal, err
+}
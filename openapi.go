@@ -0,0 +1,461 @@
+package gobbi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidOpenAPI    = errors.New("invalid openapi document")
+	ErrOpenAPINotMatched = fmt.Errorf("%w: response does not conform to openapi", ErrTestFailure)
+)
+
+// OpenAPI is an OpenAPI 3 document which responses can be checked against.
+// Only what is needed to find operations and check responses is used.
+type OpenAPI struct {
+	FileName string
+	doc      map[string]interface{}
+	url      string
+	compiler *jsonschema.Compiler
+	schemas  map[string]*jsonschema.Schema
+	lock     sync.Mutex
+}
+
+// LoadOpenAPI reads an OpenAPI 3 document, in YAML or JSON, from fileName.
+func LoadOpenAPI(fileName string) (*OpenAPI, error) {
+	rawBytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	err = yaml.Unmarshal(rawBytes, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidOpenAPI, fileName, err)
+	}
+	doc, ok := normalizeYAML(raw).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s: not a mapping", ErrInvalidOpenAPI, fileName)
+	}
+	version, _ := doc["openapi"].(string)
+	if !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("%w: %s: unsupported version %q", ErrInvalidOpenAPI, fileName, version)
+	}
+
+	absName, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, err
+	}
+	o := &OpenAPI{
+		FileName: fileName,
+		doc:      doc,
+		url:      fileURL(absName),
+		compiler: jsonschema.NewCompiler(),
+		schemas:  map[string]*jsonschema.Schema{},
+	}
+	// Schemas in 3.0 are an extended subset of draft 4. In 3.1 they are
+	// 2020-12.
+	schemaDoc := interface{}(doc)
+	if strings.HasPrefix(version, "3.0") {
+		o.compiler.Draft = jsonschema.Draft4
+		schemaDoc = nullableToTypes(doc)
+	}
+	jsonBytes, err := json.Marshal(schemaDoc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidOpenAPI, fileName, err)
+	}
+	err = o.compiler.AddResource(o.url, bytes.NewReader(jsonBytes))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidOpenAPI, fileName, err)
+	}
+	return o, nil
+}
+
+// normalizeYAML makes the maps in v, decoded from YAML, have string keys,
+// as they do when decoded from JSON. Status codes are often integer keys.
+func normalizeYAML(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, item := range x {
+			x[k] = normalizeYAML(item)
+		}
+		return x
+	case map[interface{}]interface{}:
+		normal := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			normal[fmt.Sprint(k)] = normalizeYAML(item)
+		}
+		return normal
+	case []interface{}:
+		for i, item := range x {
+			x[i] = normalizeYAML(item)
+		}
+		return x
+	}
+	return v
+}
+
+// nullableToTypes returns a copy of v, part of a 3.0 document, in which
+// schemas with nullable: true also allow null in their type and enum, as
+// draft 4 has no nullable keyword.
+func nullableToTypes(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		schema := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			schema[k] = nullableToTypes(item)
+		}
+		if nullable, _ := x["nullable"].(bool); !nullable {
+			return schema
+		}
+		if t, ok := x["type"].(string); ok {
+			schema["type"] = []interface{}{t, "null"}
+		}
+		if enum, ok := schema["enum"].([]interface{}); ok && !containsNil(enum) {
+			schema["enum"] = append(enum, nil)
+		}
+		return schema
+	case []interface{}:
+		items := make([]interface{}, len(x))
+		for i, item := range x {
+			items[i] = nullableToTypes(item)
+		}
+		return items
+	}
+	return v
+}
+
+func containsNil(items []interface{}) bool {
+	for _, item := range items {
+		if item == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// openAPINode is a value in the document and its JSON pointer.
+type openAPINode struct {
+	value   map[string]interface{}
+	pointer string
+}
+
+// child returns the mapping at key in n, following a $ref if there is one.
+func (o *OpenAPI) child(n openAPINode, key string) (openAPINode, bool) {
+	value, ok := n.value[key].(map[string]interface{})
+	if !ok {
+		return openAPINode{}, false
+	}
+	return o.deref(openAPINode{value: value, pointer: n.pointer + "/" + escapePointer(key)})
+}
+
+// deref follows the $ref of n, if it has one, to a mapping in the same
+// document.
+func (o *OpenAPI) deref(n openAPINode) (openAPINode, bool) {
+	for i := 0; i < 10; i++ {
+		ref, ok := n.value["$ref"].(string)
+		if !ok {
+			return n, true
+		}
+		if !strings.HasPrefix(ref, "#/") {
+			// References to other documents are not supported.
+			return openAPINode{}, false
+		}
+		value, ok := lookupPointer(o.doc, ref[1:])
+		if !ok {
+			return openAPINode{}, false
+		}
+		n = openAPINode{value: value, pointer: ref[1:]}
+	}
+	return openAPINode{}, false
+}
+
+// lookupPointer returns the mapping at pointer in doc.
+func lookupPointer(doc map[string]interface{}, pointer string) (map[string]interface{}, bool) {
+	current := doc
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		next, ok := current[token].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return current, true
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// serverPaths returns the paths of the servers in the document, in the
+// order they are declared.
+func (o *OpenAPI) serverPaths() []string {
+	bases := []string{}
+	servers, _ := o.doc["servers"].([]interface{})
+	for _, s := range servers {
+		server, _ := s.(map[string]interface{})
+		serverURL, _ := server["url"].(string)
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, strings.TrimSuffix(u.Path, "/"))
+	}
+	return bases
+}
+
+// basePaths returns the paths of the servers in the document, longest
+// first, so requests can be matched with the paths of operations.
+func (o *OpenAPI) basePaths() []string {
+	bases := []string{}
+	for _, base := range o.serverPaths() {
+		if base != "" {
+			bases = append(bases, base)
+		}
+	}
+	sort.Slice(bases, func(i, j int) bool {
+		return len(bases[i]) > len(bases[j])
+	})
+	return bases
+}
+
+// findOperation returns the path template and operation for method and
+// the path of requestURL. Paths without parameters are preferred to
+// templates.
+func (o *OpenAPI) findOperation(method, requestURL string) (string, openAPINode, error) {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", openAPINode{}, err
+	}
+	requestPath := u.Path
+	for _, base := range o.basePaths() {
+		if strings.HasPrefix(requestPath, base+"/") || requestPath == base {
+			requestPath = strings.TrimPrefix(requestPath, base)
+			break
+		}
+	}
+	if requestPath == "" {
+		requestPath = "/"
+	}
+
+	paths := openAPINode{pointer: "/paths"}
+	paths.value, _ = o.doc["paths"].(map[string]interface{})
+	best := ""
+	bestParams := -1
+	for template := range paths.value {
+		params, ok := matchPathTemplate(template, requestPath)
+		if !ok {
+			continue
+		}
+		if bestParams == -1 || params < bestParams || (params == bestParams && template < best) {
+			best = template
+			bestParams = params
+		}
+	}
+	if bestParams == -1 {
+		return "", openAPINode{}, fmt.Errorf("%w: no path for %s", ErrOpenAPINotMatched, requestPath)
+	}
+	pathItem, ok := o.child(paths, best)
+	if !ok {
+		return "", openAPINode{}, fmt.Errorf("%w: invalid path item %s", ErrOpenAPINotMatched, best)
+	}
+	operation, ok := o.child(pathItem, strings.ToLower(method))
+	if !ok {
+		return "", openAPINode{}, fmt.Errorf("%w: no %s operation for %s", ErrOpenAPINotMatched, method, best)
+	}
+	return best, operation, nil
+}
+
+// matchPathTemplate reports whether requestPath matches template, and the
+// number of parameters in template.
+func matchPathTemplate(template, requestPath string) (int, bool) {
+	templateParts := strings.Split(template, "/")
+	requestParts := strings.Split(requestPath, "/")
+	if len(templateParts) != len(requestParts) {
+		return 0, false
+	}
+	params := 0
+	for i, part := range templateParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if requestParts[i] == "" {
+				return 0, false
+			}
+			params++
+			continue
+		}
+		if part != requestParts[i] {
+			return 0, false
+		}
+	}
+	return params, true
+}
+
+// findResponse returns the response declared in operation for status,
+// trying the exact status, then a range such as 2XX, then default.
+func (o *OpenAPI) findResponse(operation openAPINode, status int) (openAPINode, bool) {
+	responses, ok := o.child(operation, "responses")
+	if !ok {
+		return openAPINode{}, false
+	}
+	for _, key := range []string{fmt.Sprint(status), fmt.Sprintf("%dXX", status/100), fmt.Sprintf("%dxx", status/100), "default"} {
+		if response, ok := o.child(responses, key); ok {
+			return response, true
+		}
+	}
+	return openAPINode{}, false
+}
+
+// findMediaType returns the key in content matching contentType, trying
+// the exact type, then type/* and then */*.
+func findMediaType(content map[string]interface{}, contentType string) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	major := strings.SplitN(mediaType, "/", 2)[0]
+	for _, candidate := range []string{mediaType, major + "/*", "*/*"} {
+		for key := range content {
+			if declared, _, err := mime.ParseMediaType(key); err == nil && declared == candidate {
+				return key, true
+			}
+		}
+	}
+	return "", false
+}
+
+// schema returns the compiled schema at pointer.
+func (o *OpenAPI) schema(pointer string) (*jsonschema.Schema, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if schema, ok := o.schemas[pointer]; ok {
+		return schema, nil
+	}
+	schema, err := o.compiler.Compile(o.url + "#" + pointer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidOpenAPI, o.FileName, err)
+	}
+	o.schemas[pointer] = schema
+	return schema, nil
+}
+
+// CheckResponse returns an error for each way the response to c does not
+// conform to the operation for the method and URL it came from.
+func (o *OpenAPI) CheckResponse(c *Case) []error {
+	// After redirects the response is to the final request, whose method
+	// may differ too.
+	method := c.Method
+	if resp := c.GetResponse(); resp != nil && resp.Request != nil {
+		method = resp.Request.Method
+	}
+	template, operation, err := o.findOperation(method, c.GetFinalURL())
+	if err != nil {
+		return []error{err}
+	}
+	where := fmt.Sprintf("%s %s", method, template)
+	status := c.GetResponse().StatusCode
+	response, ok := o.findResponse(operation, status)
+	if !ok {
+		return []error{fmt.Errorf("%w: %s: status %d not declared", ErrOpenAPINotMatched, where, status)}
+	}
+
+	problems := []error{}
+	header := c.GetResponseHeader()
+	if headers, ok := o.child(response, "headers"); ok {
+		for name := range headers.value {
+			h, ok := o.child(headers, name)
+			if !ok {
+				continue
+			}
+			// Content-Type is described by content, not headers.
+			if strings.EqualFold(name, "content-type") {
+				continue
+			}
+			if required, _ := h.value["required"].(bool); required && header.Get(name) == "" {
+				problems = append(problems, fmt.Errorf("%w: %s: required header %s missing", ErrOpenAPINotMatched, where, name))
+			}
+		}
+	}
+
+	body, err := io.ReadAll(c.GetResponseBody())
+	if err != nil {
+		return append(problems, err)
+	}
+	if len(body) == 0 {
+		return problems
+	}
+	content, ok := o.child(response, "content")
+	if !ok {
+		return append(problems, fmt.Errorf("%w: %s: status %d has no content declared", ErrOpenAPINotMatched, where, status))
+	}
+	contentType := header.Get("content-type")
+	mediaType, ok := findMediaType(content.value, contentType)
+	if !ok {
+		return append(problems, fmt.Errorf("%w: %s: content-type %s not declared", ErrOpenAPINotMatched, where, contentType))
+	}
+	media, ok := o.child(content, mediaType)
+	if !ok {
+		return problems
+	}
+	if _, ok := media.value["schema"]; !ok || !isJSONContentType(contentType) {
+		return problems
+	}
+	schema, err := o.schema(media.pointer + "/schema")
+	if err != nil {
+		return append(problems, err)
+	}
+	var instance interface{}
+	err = json.Unmarshal(body, &instance)
+	if err != nil {
+		return append(problems, fmt.Errorf("%w: %s: invalid JSON body: %v", ErrOpenAPINotMatched, where, err))
+	}
+	err = schema.Validate(instance)
+	var validationError *jsonschema.ValidationError
+	switch {
+	case err == nil:
+	case errors.As(err, &validationError):
+		for _, violation := range schemaViolations(validationError) {
+			problems = append(problems, fmt.Errorf("%w: %s: %v", ErrOpenAPINotMatched, where, violation))
+		}
+	default:
+		problems = append(problems, err)
+	}
+	return problems
+}
+
+// isJSONContentType reports whether contentType is JSON, such as
+// application/json or application/problem+json.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// OpenAPIResponseHandler checks that responses conform to the OpenAPI
+// document set on the suite, if there is one.
+type OpenAPIResponseHandler struct {
+	BaseResponseHandler
+}
+
+func (h *OpenAPIResponseHandler) Assert(c *Case) {
+	if c.openAPI == nil {
+		return
+	}
+	for _, problem := range c.openAPI.CheckResponse(c) {
+		c.Errorf("%v", problem)
+	}
+}
@@ -0,0 +1,381 @@
+package gobbi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidRecordedRequest = errors.New("invalid request to record")
+)
+
+// RecordedResponseHeaders are the response headers asserted, when present,
+// in recorded cases.
+var RecordedResponseHeaders = []string{"content-type", "location"}
+
+// unrecordedRequestHeaders are request headers set by clients or proxies
+// rather than by whoever made the request.
+var unrecordedRequestHeaders = map[string]bool{
+	"accept-encoding":   true,
+	"connection":        true,
+	"content-length":    true,
+	"host":              true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"te":                true,
+	"trailer":           true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+	"user-agent":        true,
+	"x-forwarded-for":   true,
+	"x-forwarded-host":  true,
+	"x-forwarded-proto": true,
+}
+
+// secretRequestHeaders are request headers holding credentials. They are
+// recorded in suites as $ENVIRON substitutions of the variables they map
+// to, and not recorded in cassettes, as both are meant to be committed.
+var secretRequestHeaders = map[string]string{
+	"authorization":       "GOBBI_AUTHORIZATION",
+	"cookie":              "GOBBI_COOKIE",
+	"proxy-authorization": "GOBBI_PROXY_AUTHORIZATION",
+}
+
+// Recorder records HTTP requests and their responses as the cases of a
+// suite, with assertions inferred from the responses: the status, the
+// RecordedResponseHeaders and the top-level scalar fields of JSON objects.
+// Credentials in secretRequestHeaders, such as authorization, are recorded
+// as $ENVIRON substitutions, so they must be set in the environment to run
+// the suite.
+// Requests may come through Proxy, be sent with Do, or be read from a list
+// of curl command lines with DoLines. It is safe for concurrent use.
+type Recorder struct {
+	// BaseURL is the URL recorded URLs are made relative to, when they
+	// start with it.
+	BaseURL string
+	lock    sync.Mutex
+	cases   []Case
+}
+
+// NewRecorder creates a Recorder whose requests are to, and are recorded
+// relative to, baseURL.
+func NewRecorder(baseURL string) *Recorder {
+	return &Recorder{BaseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// SuiteYAML returns a suite of the cases recorded so far, in the order
+// their responses were received.
+func (r *Recorder) SuiteYAML() *SuiteYAML {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	tests := make([]Case, len(r.cases))
+	copy(tests, r.cases)
+	return &SuiteYAML{Tests: tests}
+}
+
+// Record adds a case for req, whose body was reqBody, and its response
+// resp, whose body was respBody.
+func (r *Recorder) Record(req *http.Request, reqBody []byte, resp *http.Response, respBody []byte) {
+	requestURL := req.URL.String()
+	if r.BaseURL != "" && strings.HasPrefix(requestURL, r.BaseURL+"/") {
+		requestURL = strings.TrimPrefix(requestURL, r.BaseURL)
+	}
+
+	c := Case{}
+	c.Name = fmt.Sprintf("%s %s", req.Method, req.URL.Path)
+	setMethodShortcut(&c, req.Method, requestURL)
+	for name := range req.Header {
+		lowerName := strings.ToLower(name)
+		if unrecordedRequestHeaders[lowerName] {
+			continue
+		}
+		if c.RequestHeaders == nil {
+			c.RequestHeaders = map[string]string{}
+		}
+		if variable, ok := secretRequestHeaders[lowerName]; ok {
+			c.RequestHeaders[lowerName] = "$ENVIRON['" + variable + "']"
+			continue
+		}
+		c.RequestHeaders[lowerName] = strings.Join(req.Header.Values(name), ", ")
+	}
+	if len(reqBody) > 0 {
+		var data interface{}
+		switch {
+		case isJSONContentType(req.Header.Get("content-type")) && json.Unmarshal(reqBody, &data) == nil:
+			c.Data = data
+		case utf8.Valid(reqBody):
+			c.Data = string(reqBody)
+		default:
+			c.Desc = "binary request body not recorded"
+		}
+	}
+
+	c.Status = resp.StatusCode
+	for _, name := range RecordedResponseHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			if c.ResponseHeaders == nil {
+				c.ResponseHeaders = map[string]string{}
+			}
+			c.ResponseHeaders[name] = r.relativeToBase(value)
+		}
+	}
+	var object map[string]interface{}
+	if isJSONContentType(resp.Header.Get("content-type")) && json.Unmarshal(respBody, &object) == nil {
+		for key, value := range object {
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				continue
+			}
+			if c.ResponseJSONPaths == nil {
+				c.ResponseJSONPaths = map[string]interface{}{}
+			}
+			c.ResponseJSONPaths[jsonPathChild("$", key)] = value
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cases = append(r.cases, c)
+}
+
+// relativeToBase replaces the scheme and host of BaseURL at the start of
+// value with $SCHEME://$NETLOC, so the case passes wherever the service is.
+func (r *Recorder) relativeToBase(value string) string {
+	base, err := url.Parse(r.BaseURL)
+	if err != nil || base.Host == "" {
+		return value
+	}
+	prefix := base.Scheme + "://" + base.Host
+	if value != prefix && !strings.HasPrefix(value, prefix+"/") && !strings.HasPrefix(value, prefix+"?") {
+		return value
+	}
+	return "$SCHEME://$NETLOC" + strings.TrimPrefix(value, prefix)
+}
+
+// Proxy returns a handler which forwards requests to BaseURL, and records
+// them and their responses.
+func (r *Recorder) Proxy() (http.Handler, error) {
+	target, err := url.Parse(r.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reqBody, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recorded := req.Clone(req.Context())
+		recorded.URL = &url.URL{Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		director := proxy.Director
+		proxy.Director = func(out *http.Request) {
+			director(out)
+			// The service sees its own host, not the proxy's.
+			out.Host = target.Host
+		}
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			respBody, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader(respBody))
+			r.Record(recorded, reqBody, resp, respBody)
+			return nil
+		}
+		proxy.ServeHTTP(w, req)
+	}), nil
+}
+
+// Do sends req with client, without following redirects, and records it
+// and its response.
+func (r *Recorder) Do(client *http.Client, req *http.Request) error {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := noRedirects.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	r.Record(req, reqBody, resp, respBody)
+	return nil
+}
+
+// DoLines sends and records, in order, the requests in lines, one per
+// line, written as curl command lines such as
+//
+//	curl -X POST -H 'content-type: application/json' -d '{"a": 1}' /things
+//
+// The curl is optional, as is -X, so GET /things is a request too. The
+// options understood are -X, -H and -d and their long forms. URLs are
+// relative to BaseURL. Blank lines and lines starting with # are skipped.
+func (r *Recorder) DoLines(client *http.Client, lines io.Reader) error {
+	scanner := bufio.NewScanner(lines)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		req, err := r.parseRequestLine(line)
+		if err != nil {
+			return fmt.Errorf("%w: line %d: %v", ErrInvalidRecordedRequest, lineNumber, err)
+		}
+		err = r.Do(client, req)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseRequestLine makes a request from a curl command line.
+func (r *Recorder) parseRequestLine(line string) (*http.Request, error) {
+	args, err := splitCommandLine(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 0 && args[0] == "curl" {
+		args = args[1:]
+	}
+	method := ""
+	requestURL := ""
+	header := http.Header{}
+	var data *string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			switch {
+			case method == "" && requestURL == "" && arg == strings.ToUpper(arg) && !strings.Contains(arg, "/"):
+				method = arg
+			case requestURL == "":
+				requestURL = arg
+			default:
+				return nil, fmt.Errorf("unexpected argument %q", arg)
+			}
+			continue
+		}
+		if i+1 >= len(args) {
+			return nil, fmt.Errorf("missing value for %s", arg)
+		}
+		i++
+		switch arg {
+		case "-X", "--request":
+			method = args[i]
+		case "-H", "--header":
+			name, value, ok := strings.Cut(args[i], ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid header %q", args[i])
+			}
+			header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		case "-d", "--data", "--data-raw", "--data-binary":
+			data = &args[i]
+		default:
+			return nil, fmt.Errorf("unknown option %s", arg)
+		}
+	}
+	if requestURL == "" {
+		return nil, errors.New("no url")
+	}
+	if !strings.Contains(requestURL, "://") {
+		requestURL = r.BaseURL + "/" + strings.TrimPrefix(requestURL, "/")
+	}
+	if method == "" {
+		method = http.MethodGet
+		if data != nil {
+			method = http.MethodPost
+		}
+	}
+	var body io.Reader
+	if data != nil {
+		body = strings.NewReader(*data)
+		// As curl does.
+		if header.Get("content-type") == "" {
+			header.Set("content-type", "application/x-www-form-urlencoded")
+		}
+	}
+	req, err := http.NewRequest(method, requestURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	return req, nil
+}
+
+// splitCommandLine splits line into arguments as a POSIX shell would,
+// with quotes and backslashes, but no expansions.
+func splitCommandLine(line string) ([]string, error) {
+	args := []string{}
+	var current strings.Builder
+	inArg := false
+	quote := rune(0)
+	escaped := false
+	for _, ch := range line {
+		switch {
+		case escaped:
+			// In double quotes a backslash only escapes some characters.
+			if quote == '"' && !strings.ContainsRune("\"\\$`", ch) {
+				current.WriteRune('\\')
+			}
+			current.WriteRune(ch)
+			escaped = false
+		case ch == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				current.WriteRune(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inArg = true
+		case ch == ' ' || ch == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(ch)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,310 @@
+package gobbi
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result is the recorded outcome of running a suite or case.
+type Result struct {
+	Name     string
+	Outcome  string
+	Messages []string
+	Logs     []string
+	Duration time.Duration
+	Children []*Result
+}
+
+// RecordingReporter is a Reporter which records the Result of everything
+// run while passing it on to another Reporter. The Results can then be
+// written as JUnit XML or TAP.
+type RecordingReporter struct {
+	inner  Reporter
+	result *Result
+	lock   *sync.Mutex
+}
+
+// NewRecordingReporter creates a RecordingReporter passing on to inner.
+func NewRecordingReporter(inner Reporter) *RecordingReporter {
+	return &RecordingReporter{
+		inner:  inner,
+		result: &Result{Outcome: ResultPass},
+		lock:   &sync.Mutex{},
+	}
+}
+
+// Results returns the Results of the suites or cases run directly by r.
+func (r *RecordingReporter) Results() []*Result {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.result.Children
+}
+
+func (r *RecordingReporter) Run(name string, f func(Reporter)) bool {
+	child := &RecordingReporter{
+		result: &Result{Name: name, Outcome: ResultPass},
+		lock:   r.lock,
+	}
+	r.lock.Lock()
+	r.result.Children = append(r.result.Children, child.result)
+	r.lock.Unlock()
+	return r.inner.Run(name, func(u Reporter) {
+		start := time.Now()
+		defer func() {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			child.result.Duration = time.Since(start)
+			if child.result.Outcome == ResultFail {
+				r.result.Outcome = ResultFail
+			}
+		}()
+		child.inner = u
+		f(child)
+	})
+}
+
+// setOutcome records outcome unless a more important one is already
+// recorded. Failure trumps xfail which trumps skip.
+func (r *RecordingReporter) setOutcome(outcome string, message string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.result.Messages = append(r.result.Messages, message)
+	current := r.result.Outcome
+	switch {
+	case current == ResultFail:
+	case current == ResultXfail && outcome == ResultSkip:
+	default:
+		r.result.Outcome = outcome
+	}
+}
+
+func (r *RecordingReporter) Errorf(format string, args ...any) {
+	r.setOutcome(ResultFail, fmt.Sprintf(format, args...))
+	r.inner.Errorf(format, args...)
+}
+
+func (r *RecordingReporter) Fatalf(format string, args ...any) {
+	r.setOutcome(ResultFail, fmt.Sprintf(format, args...))
+	r.inner.Fatalf(format, args...)
+}
+
+func (r *RecordingReporter) Skipf(format string, args ...any) {
+	r.setOutcome(ResultSkip, fmt.Sprintf(format, args...))
+	r.inner.Skipf(format, args...)
+}
+
+func (r *RecordingReporter) Logf(format string, args ...any) {
+	r.lock.Lock()
+	r.result.Logs = append(r.result.Logs, fmt.Sprintf(format, args...))
+	r.lock.Unlock()
+	r.inner.Logf(format, args...)
+}
+
+func (r *RecordingReporter) Parallel() {
+	r.inner.Parallel()
+}
+
+func (r *RecordingReporter) Deadline() (time.Time, bool) {
+	if d, ok := r.inner.(deadliner); ok {
+		return d.Deadline()
+	}
+	return time.Time{}, false
+}
+
+func (r *RecordingReporter) Xfailf(format string, args ...any) {
+	r.setOutcome(ResultXfail, fmt.Sprintf(format, args...))
+	if x, ok := r.inner.(XfailReporter); ok {
+		x.Xfailf(format, args...)
+	} else {
+		r.inner.Logf(format, args...)
+	}
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Error    *junitMessage   `xml:"error,omitempty"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message  string `xml:"message,attr"`
+	Type     string `xml:"type,attr,omitempty"`
+	Contents string `xml:",chardata"`
+}
+
+// WriteJUnit writes the recorded Results as JUnit XML, with a testsuite
+// for each Suite and a testcase for each Case. Expected failures are
+// reported as skipped with a type of xfail.
+func (r *RecordingReporter) WriteJUnit(w io.Writer) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	report := junitTestSuites{}
+	var total time.Duration
+	for _, suite := range r.result.Children {
+		js := junitTestSuite{
+			Name: suite.Name,
+			Time: junitTime(suite.Duration),
+		}
+		total += suite.Duration
+		// A suite can fail without any case failing, when its fixtures do.
+		if suite.Outcome == ResultFail && len(suite.Messages) > 0 {
+			js.Errors++
+			js.Error = &junitMessage{
+				Message:  suite.Messages[0],
+				Contents: strings.Join(suite.Messages, "\n"),
+			}
+		}
+		for _, c := range suite.Children {
+			messages := collectMessages(c, "")
+			jc := junitTestCase{
+				Name:      c.Name,
+				ClassName: suite.Name,
+				Time:      junitTime(c.Duration),
+				SystemOut: strings.Join(c.Logs, "\n"),
+			}
+			message := junitMessage{Contents: strings.Join(messages, "\n")}
+			if len(messages) > 0 {
+				message.Message = messages[0]
+			}
+			switch c.Outcome {
+			case ResultFail:
+				js.Failures++
+				jc.Failure = &message
+			case ResultSkip:
+				js.Skipped++
+				jc.Skipped = &message
+			case ResultXfail:
+				js.Skipped++
+				message.Type = "xfail"
+				jc.Skipped = &message
+			}
+			js.Tests++
+			js.Cases = append(js.Cases, jc)
+		}
+		report.Tests += js.Tests
+		report.Failures += js.Failures
+		report.Skipped += js.Skipped
+		report.Suites = append(report.Suites, js)
+	}
+	report.Time = junitTime(total)
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(report)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// WriteTAP writes the recorded Results as a TAP version 13 stream with a
+// test point for each Case. Expected failures are TODO test points.
+func (r *RecordingReporter) WriteTAP(w io.Writer) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	count := 0
+	for _, suite := range r.result.Children {
+		count += len(suite.Children)
+	}
+	_, err := fmt.Fprintf(w, "TAP version 13\n1..%d\n", count)
+	if err != nil {
+		return err
+	}
+	number := 0
+	for _, suite := range r.result.Children {
+		for _, c := range suite.Children {
+			number++
+			name := suite.Name + "/" + c.Name
+			messages := collectMessages(c, "")
+			var point string
+			switch c.Outcome {
+			case ResultFail:
+				point = fmt.Sprintf("not ok %d - %s%s", number, name, tapDiagnostic(messages))
+			case ResultSkip:
+				point = fmt.Sprintf("ok %d - %s # SKIP %s", number, name, tapDirective(messages))
+			case ResultXfail:
+				point = fmt.Sprintf("not ok %d - %s # TODO %s", number, name, tapDirective(messages))
+			default:
+				point = fmt.Sprintf("ok %d - %s", number, name)
+			}
+			_, err := io.WriteString(w, point+"\n")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// collectMessages returns the messages of result and, prefixed with their
+// names, those of its children, such as priors run by a case.
+func collectMessages(result *Result, prefix string) []string {
+	messages := []string{}
+	for _, m := range result.Messages {
+		messages = append(messages, prefix+m)
+	}
+	for _, child := range result.Children {
+		messages = append(messages, collectMessages(child, prefix+child.Name+": ")...)
+	}
+	return messages
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// tapDirective makes the first message suitable for the reason of a TAP
+// directive.
+func tapDirective(messages []string) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	return strings.SplitN(messages[0], "\n", 2)[0]
+}
+
+// tapDiagnostic formats messages as a YAML block following a test point.
+func tapDiagnostic(messages []string) string {
+	if len(messages) == 0 {
+		return ""
+	}
+	lines := []string{"", "  ---", "  message: |"}
+	for _, m := range messages {
+		for _, line := range strings.Split(m, "\n") {
+			lines = append(lines, "    "+line)
+		}
+	}
+	lines = append(lines, "  ...")
+	return strings.Join(lines, "\n")
+}
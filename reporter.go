@@ -0,0 +1,243 @@
+package gobbi
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A Reporter is told the outcome of running suites and cases, decoupling
+// them from testing.T so they can be run by other frameworks or commands.
+// It has the same semantics as the matching methods on testing.T: Run runs
+// f as a named child, Fatalf and Skipf stop the calling goroutine, and
+// Parallel signals that a child may run alongside its siblings, which a
+// Reporter may ignore.
+type Reporter interface {
+	Run(name string, f func(Reporter)) bool
+	Errorf(format string, args ...any)
+	Fatalf(format string, args ...any)
+	Skipf(format string, args ...any)
+	Logf(format string, args ...any)
+	Parallel()
+}
+
+// An XfailReporter is a Reporter which is told when a Case with xfail set
+// fails as expected. Other Reporters see the failure as a log message, or
+// a skip if the failure is fatal.
+type XfailReporter interface {
+	Reporter
+	Xfailf(format string, args ...any)
+}
+
+// deadliner is implemented by Reporters which, like testing.T, have a
+// deadline by which what they run must be done.
+type deadliner interface {
+	Deadline() (time.Time, bool)
+}
+
+type testingReporter struct {
+	t *testing.T
+}
+
+// NewTestingReporter returns a Reporter which reports to t. It is what
+// Suite.Execute and MultiSuite.Execute use.
+func NewTestingReporter(t *testing.T) Reporter {
+	return &testingReporter{t: t}
+}
+
+func (r *testingReporter) Run(name string, f func(Reporter)) bool {
+	return r.t.Run(name, func(u *testing.T) {
+		f(NewTestingReporter(u))
+	})
+}
+
+func (r *testingReporter) Errorf(format string, args ...any) {
+	r.t.Helper()
+	r.t.Errorf(format, args...)
+}
+
+func (r *testingReporter) Fatalf(format string, args ...any) {
+	r.t.Helper()
+	r.t.Fatalf(format, args...)
+}
+
+func (r *testingReporter) Skipf(format string, args ...any) {
+	r.t.Helper()
+	r.t.Skipf(format, args...)
+}
+
+func (r *testingReporter) Logf(format string, args ...any) {
+	r.t.Helper()
+	r.t.Logf(format, args...)
+}
+
+func (r *testingReporter) Parallel() {
+	r.t.Parallel()
+}
+
+func (r *testingReporter) Deadline() (time.Time, bool) {
+	return r.t.Deadline()
+}
+
+// Outcomes of running a case, as reported by TextReporter.
+const (
+	ResultPass  = "PASS"
+	ResultFail  = "FAIL"
+	ResultSkip  = "SKIP"
+	ResultXfail = "XFAIL"
+)
+
+// TextReporter is a Reporter for running suites outside of go test. It
+// writes a line with the result of each case, and the messages of
+// failures, to an io.Writer.
+type TextReporter struct {
+	name     string
+	shared   *textShared
+	failed   bool
+	skipped  bool
+	xfailed  bool
+	children int
+	messages []string
+}
+
+// textShared is the state shared by a TextReporter and its children.
+type textShared struct {
+	lock    sync.Mutex
+	out     io.Writer
+	verbose bool
+	counts  map[string]int
+}
+
+// NewTextReporter creates a TextReporter writing to out. If verbose is
+// true log messages are written for all cases, not only failures.
+func NewTextReporter(out io.Writer, verbose bool) *TextReporter {
+	return &TextReporter{
+		shared: &textShared{
+			out:     out,
+			verbose: verbose,
+			counts:  map[string]int{},
+		},
+	}
+}
+
+func (r *TextReporter) Run(name string, f func(Reporter)) bool {
+	fullName := name
+	if r.name != "" {
+		fullName = r.name + "/" + name
+	}
+	child := &TextReporter{
+		name:   fullName,
+		shared: r.shared,
+	}
+	r.shared.lock.Lock()
+	r.children++
+	r.shared.lock.Unlock()
+
+	// Run in a goroutine so that Fatalf and Skipf can stop f.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(child)
+	}()
+	<-done
+
+	result := child.Result()
+	if result == ResultFail {
+		r.fail()
+	}
+	child.report(result)
+	return result != ResultFail
+}
+
+// Result returns the outcome of what has been reported so far.
+func (r *TextReporter) Result() string {
+	r.shared.lock.Lock()
+	defer r.shared.lock.Unlock()
+	switch {
+	case r.failed:
+		return ResultFail
+	case r.xfailed:
+		return ResultXfail
+	case r.skipped:
+		return ResultSkip
+	}
+	return ResultPass
+}
+
+// Failed reports whether anything run has failed.
+func (r *TextReporter) Failed() bool {
+	return r.Result() == ResultFail
+}
+
+// Summary returns the number of cases with each outcome.
+func (r *TextReporter) Summary() string {
+	r.shared.lock.Lock()
+	defer r.shared.lock.Unlock()
+	counts := r.shared.counts
+	return fmt.Sprintf("%d passed, %d failed, %d skipped, %d xfailed",
+		counts[ResultPass], counts[ResultFail], counts[ResultSkip], counts[ResultXfail])
+}
+
+func (r *TextReporter) report(result string) {
+	r.shared.lock.Lock()
+	defer r.shared.lock.Unlock()
+	// Only count cases, not the suites containing them.
+	if r.children == 0 {
+		r.shared.counts[result]++
+	}
+	fmt.Fprintf(r.shared.out, "%s: %s\n", result, r.name)
+	if result == ResultFail || r.shared.verbose {
+		for _, m := range r.messages {
+			fmt.Fprintf(r.shared.out, "    %s\n", strings.ReplaceAll(m, "\n", "\n    "))
+		}
+	}
+}
+
+func (r *TextReporter) fail() {
+	r.shared.lock.Lock()
+	defer r.shared.lock.Unlock()
+	r.failed = true
+}
+
+func (r *TextReporter) log(format string, args ...any) {
+	r.shared.lock.Lock()
+	defer r.shared.lock.Unlock()
+	r.messages = append(r.messages, fmt.Sprintf(format, args...))
+}
+
+func (r *TextReporter) Errorf(format string, args ...any) {
+	r.log(format, args...)
+	r.fail()
+}
+
+func (r *TextReporter) Fatalf(format string, args ...any) {
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+func (r *TextReporter) Skipf(format string, args ...any) {
+	r.log(format, args...)
+	r.shared.lock.Lock()
+	r.skipped = true
+	r.shared.lock.Unlock()
+	runtime.Goexit()
+}
+
+func (r *TextReporter) Logf(format string, args ...any) {
+	r.log(format, args...)
+}
+
+// Parallel does nothing, TextReporter runs everything in series so the
+// output is readable.
+func (r *TextReporter) Parallel() {}
+
+func (r *TextReporter) Xfailf(format string, args ...any) {
+	r.log(format, args...)
+	r.shared.lock.Lock()
+	defer r.shared.lock.Unlock()
+	r.xfailed = true
+}
@@ -2,28 +2,44 @@ package gobbi
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httputil"
 	"net/url"
 	"strconv"
 	"strings"
-	"testing"
+	"time"
 )
 
 const (
-	// TODO: change this
+	// DefaultHTTPTimeout is the number of seconds a request may take when
+	// a Case does not set a timeout.
 	DefaultHTTPTimeout = 30
+	// DefaultPollDelay is the number of seconds between poll attempts when
+	// no delay is set, matching gabbi.
+	DefaultPollDelay = 1
+	// DefaultMaxRedirects is the number of redirects followed when a Case
+	// sets redirects to true, matching net/http.
+	DefaultMaxRedirects = 10
 )
 
+// redirectsKey is the request context key holding the RedirectCount for
+// the Case making the request.
+type redirectsKey struct{}
+
 type Requester interface {
 	Do(*Case)
 	ExecuteOne(*Case)
 }
 
 type BaseClient struct {
-	Client *http.Client
+	Client         *http.Client
+	insecureClient *http.Client
 }
 
 func NewClient() *BaseClient {
@@ -35,11 +51,86 @@ func NewClient() *BaseClient {
 		httpClient := client.StandardClient()
 		httpClient.Timeout = time.Duration(DefaultHTTPTimeout * time.Second)
 	*/
-	httpClient := &http.Client{}
+	httpClient := &http.Client{
+		CheckRedirect: checkRedirect,
+	}
 	b.Client = httpClient
 	return &b
 }
 
+// SetTLSConfig makes the client use config for https requests.
+func (b *BaseClient) SetTLSConfig(config *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	b.Client.Transport = transport
+	b.insecureClient = nil
+}
+
+// UseCookieJar gives the client a cookie jar, so that cookies set by one
+// response are sent with later requests.
+func (b *BaseClient) UseCookieJar() {
+	// Ignore the error because it is always nil.
+	jar, _ := cookiejar.New(nil)
+	b.Client.Jar = jar
+	b.insecureClient = nil
+}
+
+// clientFor returns the http.Client to use for c, one which does not
+// verify server certificates if c asks for that.
+func (b *BaseClient) clientFor(c *Case) *http.Client {
+	if c.IsCertValidated() {
+		return b.Client
+	}
+	if b.insecureClient != nil {
+		return b.insecureClient
+	}
+	transport, ok := insecureTransport(b.Client.Transport)
+	if !ok {
+		// Not a transport we know how to adjust.
+		return b.Client
+	}
+	insecureClient := *b.Client
+	insecureClient.Transport = transport
+	b.insecureClient = &insecureClient
+	return b.insecureClient
+}
+
+// insecureTransport returns a copy of rt which does not verify server
+// certificates, and whether rt is a transport it knows how to adjust.
+func insecureTransport(rt http.RoundTripper) (http.RoundTripper, bool) {
+	var transport *http.Transport
+	switch x := rt.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = x.Clone()
+	case *cassetteRecorder:
+		next, ok := insecureTransport(x.next)
+		if !ok {
+			return nil, false
+		}
+		return &cassetteRecorder{cassette: x.cassette, next: next}, true
+	default:
+		return nil, false
+	}
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.InsecureSkipVerify = true
+	return transport, true
+}
+
+// checkRedirect stops following redirects once the limit set on the
+// request context is exceeded, returning the last response. By default
+// no redirects are followed.
+func checkRedirect(rq *http.Request, via []*http.Request) error {
+	limit, _ := rq.Context().Value(redirectsKey{}).(RedirectCount)
+	if len(via) > int(limit) {
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
+
 func (b *BaseClient) updateQueryString(c *Case, u string) (string, error) {
 	additionalValues := c.QueryParameters
 	if len(additionalValues) == 0 {
@@ -87,6 +178,8 @@ func scalarToString(v any) string {
 		sValue = strconv.Itoa(x)
 	case float64:
 		sValue = strconv.FormatFloat(x, 'G', -1, 64)
+	case bool:
+		sValue = strconv.FormatBool(x)
 	}
 	return sValue
 }
@@ -104,7 +197,7 @@ func (b *BaseClient) Do(c *Case) {
 			if parent == nil {
 				c.Fatalf("unable to run prior test %s because no parent", prior.Name)
 			}
-			c.GetTest().Run(prior.Name, func(u *testing.T) {
+			c.GetTest().Run(prior.Name, func(u Reporter) {
 				prior.SetTest(u, c.GetTest())
 				b.ExecuteOne(prior)
 			})
@@ -128,12 +221,52 @@ func (b *BaseClient) Do(c *Case) {
 
 	c.GetTest().Logf("url for %s is %s", c.Name, c.URL)
 
+	count, delay := c.pollSettings()
+	for attempt := 1; attempt <= count; attempt++ {
+		if count > 1 {
+			c.GetTest().Logf("poll attempt %d of %d for %s", attempt, count, c.Name)
+		}
+		if attempt == count {
+			b.attempt(c)
+			break
+		}
+		// Only the final attempt reports failures.
+		failures := c.CollectFailures(func() {
+			b.attempt(c)
+		})
+		if len(failures) == 0 {
+			break
+		}
+		for _, failure := range failures {
+			c.GetTest().Logf("poll attempt %d failed: %s", attempt, failure)
+		}
+		select {
+		case <-c.Context().Done():
+			c.Fatalf("%v", fmt.Errorf("%w: while polling: %v", ErrRequestTimeout, c.Context().Err()))
+		case <-time.After(delay):
+		}
+	}
+
+	if c.Xfail && !c.GetXFailure() {
+		c.SetDone()
+		c.GetTest().Fatalf("Test passed when expecting failure.")
+	}
+}
+
+// attempt makes one request for the case and asserts the response.
+func (b *BaseClient) attempt(c *Case) {
 	body, err := c.GetRequestBody()
 	if err != nil {
 		c.Fatalf("Error while getting request body: %v", err)
 	}
-	// TODO: NewRequestWithContext
-	rq, err := http.NewRequest(c.Method, c.URL, body)
+	contentType := ""
+	if typed, ok := body.(*typedBody); ok {
+		body, contentType = typed.Reader, typed.contentType
+	}
+	ctx, cancel := context.WithTimeout(c.Context(), c.requestTimeout())
+	defer cancel()
+	ctx = context.WithValue(ctx, redirectsKey{}, c.Redirects)
+	rq, err := http.NewRequestWithContext(ctx, c.Method, c.URL, body)
 	if err != nil {
 		c.Fatalf("Error creating request: %v", err)
 	}
@@ -157,6 +290,9 @@ func (b *BaseClient) Do(c *Case) {
 		updatedHeaders[newK] = newV
 	}
 	c.RequestHeaders = updatedHeaders
+	if contentType != "" {
+		rq.Header.Set("content-type", contentType)
+	}
 
 	if c.Verbose {
 		// TODO: Test for textual content-type header to set body true or false.
@@ -167,13 +303,16 @@ func (b *BaseClient) Do(c *Case) {
 		fmt.Printf("%s\n", strings.ReplaceAll(string(dump), "\n", "\n> "))
 	}
 
-	resp, err := b.Client.Do(rq)
+	resp, err := b.clientFor(c).Do(rq)
 	if err != nil {
-		c.Fatalf("Error making request: %v", err)
+		c.Fatalf("Error making request: %v", timeoutError(c, err))
 	}
 	defer resp.Body.Close()
+	c.SetResponse(resp)
+	c.SetFinalURL(resp.Request.URL.String())
 
 	if c.Verbose {
+		printRedirects(c, resp)
 		// TODO: Test for textual content-type header to set body true or false.
 		dump, err := httputil.DumpResponse(resp, true)
 		if err != nil {
@@ -191,7 +330,7 @@ func (b *BaseClient) Do(c *Case) {
 	// we want for being able to refer back to prior tests.
 	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		c.Fatalf("Error reading response body: %v", err)
+		c.Fatalf("Error reading response body: %v", timeoutError(c, err))
 	}
 	seekerBody := bytes.NewReader(respBody)
 	c.SetResponseBody(seekerBody)
@@ -211,9 +350,34 @@ func (b *BaseClient) Do(c *Case) {
 		handler.Assert(c)
 	}
 
-	if c.Xfail && !c.GetXFailure() {
-		c.SetDone()
-		c.GetTest().Fatalf("Test passed when expecting failure.")
+	c.captureVars()
+}
+
+// timeoutError wraps err with ErrRequestTimeout if it is the result of
+// the deadline for the request passing.
+func timeoutError(c *Case, err error) error {
+	if !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if c.Context().Err() != nil {
+		return fmt.Errorf("%w: suite deadline passed: %v", ErrRequestTimeout, err)
+	}
+	return fmt.Errorf("%w: after %s: %v", ErrRequestTimeout, c.requestTimeout(), err)
+}
+
+// printRedirects prints, oldest first, any redirect responses that were
+// followed to get resp.
+func printRedirects(c *Case, resp *http.Response) {
+	chain := []*http.Response{}
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		chain = append([]*http.Response{r}, chain...)
+	}
+	for _, r := range chain {
+		dump, err := httputil.DumpResponse(r, false)
+		if err != nil {
+			c.GetTest().Logf("unable to dump redirect response: %v", err)
+		}
+		fmt.Printf("\n\n< %s", strings.ReplaceAll(string(dump), "\n", "\n< "))
 	}
 }
 
@@ -226,7 +390,13 @@ func (b *BaseClient) ExecuteOne(c *Case) {
 		c.Skip = &newSkip
 	}
 	if c.Skip != nil && *c.Skip != "" {
+		// A skipped case counts as run, so is not run again as a prior.
+		c.SetDone()
 		c.GetTest().Skipf("<%s> skipping: %s", c.Name, *c.Skip)
 	}
+	if !c.Done() {
+		stopInnerFixtures := c.startInnerFixtures()
+		defer stopInnerFixtures()
+	}
 	b.Do(c)
 }
@@ -0,0 +1,129 @@
+package gobbi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var (
+	ErrJSONSchemaNotMatched = fmt.Errorf("%w: json schema not matched", ErrTestFailure)
+	ErrInvalidJSONSchema    = fmt.Errorf("%w: invalid json schema", ErrTestError)
+)
+
+// inlineSchemaName is the name, relative to the suite file, given to an
+// inline schema so that relative references in it resolve to files next
+// to the suite.
+const inlineSchemaName = "response_json_schema.json"
+
+// JSONSchemaHandler validates JSON responses against the JSON Schema in
+// response_json_schema, either inline or from a file named with <@.
+type JSONSchemaHandler struct {
+	BaseResponseHandler
+}
+
+func (j *JSONSchemaHandler) Assert(c *Case) {
+	if c.ResponseJSONSchema == nil {
+		return
+	}
+
+	jh := &JSONHandler{}
+	if !jh.Accepts(c) {
+		return
+	}
+
+	schema, err := j.compile(c)
+	if err != nil {
+		c.Fatalf("%v", err)
+	}
+
+	rawJSON, err := jh.ReadJSONReponse(c)
+	if err != nil {
+		c.Fatalf("Unable to read JSON from body: %v", err)
+	}
+
+	err = schema.Validate(rawJSON)
+	var validationError *jsonschema.ValidationError
+	switch {
+	case err == nil:
+	case errors.As(err, &validationError):
+		for _, violation := range schemaViolations(validationError) {
+			c.Errorf("%v", violation)
+		}
+	default:
+		c.Errorf("%v", fmt.Errorf("%w: %v", ErrInvalidJSONSchema, err))
+	}
+}
+
+// compile compiles the schema of c. Relative references in it are
+// resolved from the directory of the file it is in, or of the suite if it
+// is inline.
+func (j *JSONSchemaHandler) compile(c *Case) (*jsonschema.Schema, error) {
+	dir, err := filepath.Abs(path.Dir(c.suiteFileName))
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	var schemaURL string
+	if stringData, ok := c.ResponseJSONSchema.(string); ok && strings.HasPrefix(stringData, fileForDataPrefix) {
+		fh, err := c.ReadFileForData(stringData)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJSONSchema, err)
+		}
+		if closer, ok := fh.(io.Closer); ok {
+			defer closer.Close()
+		}
+		fileName := strings.TrimPrefix(stringData, fileForDataPrefix)
+		schemaURL = fileURL(filepath.Join(dir, fileName))
+		err = compiler.AddResource(schemaURL, fh)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJSONSchema, err)
+		}
+	} else {
+		rawSchema, err := json.Marshal(c.ResponseJSONSchema)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJSONSchema, err)
+		}
+		schemaURL = fileURL(filepath.Join(dir, inlineSchemaName))
+		err = compiler.AddResource(schemaURL, bytes.NewReader(rawSchema))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJSONSchema, err)
+		}
+	}
+	schema, err := compiler.Compile(schemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJSONSchema, err)
+	}
+	return schema, nil
+}
+
+func fileURL(fileName string) string {
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(fileName)}
+	return u.String()
+}
+
+// schemaViolations returns an error for each of the leaf causes of ve,
+// which are the individual ways the instance does not match, with the
+// JSON pointer of where in the instance it happened.
+func schemaViolations(ve *jsonschema.ValidationError) []error {
+	if len(ve.Causes) == 0 {
+		pointer := ve.InstanceLocation
+		if pointer == "" {
+			pointer = "/"
+		}
+		return []error{fmt.Errorf("%w: at %s: %s", ErrJSONSchemaNotMatched, pointer, ve.Message)}
+	}
+	violations := []error{}
+	for _, cause := range ve.Causes {
+		violations = append(violations, schemaViolations(cause)...)
+	}
+	return violations
+}
@@ -0,0 +1,207 @@
+package gobbi
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// templateRegexpFormat is the expression for a template function, named
+// by %s, with an optional cast and an optional argument, quoted or not.
+const templateRegexpFormat = `\$%s\b(:(?P<cast>\w+))?(?:\[(?:\\?"(?P<argD>.+?)\\?"|'(?P<argS>.+?)'|(?P<argU>[^\]'"]*))\])?`
+
+var (
+	ErrInvalidTemplateArgument = fmt.Errorf("%w: invalid template argument", ErrTestError)
+)
+
+// namedTimeLayouts are the names which may be used for $NOW formats, as
+// well as Go reference layouts such as 2006-01-02.
+var namedTimeLayouts = map[string]string{
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"Kitchen":     time.Kitchen,
+}
+
+// TemplateReplacer provides a template function, such as $UUID, whose
+// value is made when it is first used in a case and is the same for the
+// rest of the case, wherever it is used. An argument in brackets is part
+// of what makes it the same, so $UUID['a'] and $UUID['b'] differ. With
+// $HISTORY the value made in another case is used.
+type TemplateReplacer struct {
+	BaseStringReplacer
+	name     string
+	generate func(c *Case, arg string) (interface{}, error)
+}
+
+// newTemplateReplacers returns the built in template functions:
+//
+//	$UUID                  a random version 4 UUID
+//	$NOW['format']         the time, in UTC, as RFC3339 or in format
+//	$NOW_OFFSET['+1h']     the time after a duration, with an optional
+//	                       format following a comma
+//	$RANDOM_INT[min,max]   a random integer from min to max, inclusive
+//	$BASE64['text']        text in standard base64
+//	$SHA256['text']        the hex SHA-256 digest of text
+//
+// They run after the other replacers, including those registered with
+// RegisterStringReplacer, so their arguments may contain substitutions.
+func newTemplateReplacers() []StringReplacer {
+	return []StringReplacer{
+		newTemplateReplacer("UUID", generateUUID),
+		newTemplateReplacer("NOW", generateNow),
+		newTemplateReplacer("NOW_OFFSET", generateNowOffset),
+		newTemplateReplacer("RANDOM_INT", generateRandomInt),
+		newTemplateReplacer("BASE64", func(c *Case, arg string) (interface{}, error) {
+			return base64.StdEncoding.EncodeToString([]byte(arg)), nil
+		}),
+		newTemplateReplacer("SHA256", func(c *Case, arg string) (interface{}, error) {
+			sum := sha256.Sum256([]byte(arg))
+			return hex.EncodeToString(sum[:]), nil
+		}),
+	}
+}
+
+func newTemplateReplacer(name string, generate func(*Case, string) (interface{}, error)) *TemplateReplacer {
+	t := &TemplateReplacer{name: name, generate: generate}
+	t.regExp = regexp.MustCompile(historyRegexpString + fmt.Sprintf(templateRegexpFormat, name))
+	return t
+}
+
+// resolvesCurrentCase makes parseMatch resolve a template function
+// without $HISTORY from the case it is in, not its prior.
+func (t *TemplateReplacer) resolvesCurrentCase() {}
+
+func (t *TemplateReplacer) Resolve(c *Case, argValue, cast string) (string, error) {
+	value, err := t.ResolveValue(c, argValue)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprint(value), nil
+}
+
+// ResolveValue returns the value of the function in c, making it if this
+// is its first use there.
+func (t *TemplateReplacer) ResolveValue(c *Case, argValue string) (interface{}, error) {
+	key := t.name + "[" + argValue + "]"
+	if value, ok := c.generated[key]; ok {
+		return value, nil
+	}
+	value, err := t.generate(c, argValue)
+	if err != nil {
+		return nil, err
+	}
+	if c.generated == nil {
+		c.generated = map[string]interface{}{}
+	}
+	c.generated[key] = value
+	return value, nil
+}
+
+func (t *TemplateReplacer) Replace(c *Case, in string) (string, error) {
+	return baseReplace(t, c, in)
+}
+
+func generateUUID(c *Case, arg string) (interface{}, error) {
+	uuid := make([]byte, 16)
+	_, err := rand.Read(uuid)
+	if err != nil {
+		return nil, err
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+}
+
+// caseNow returns the time for template functions in c, which is when the
+// first of them was used, so they agree with each other.
+func caseNow(c *Case) time.Time {
+	if now, ok := c.generated["now"].(time.Time); ok {
+		return now
+	}
+	now := time.Now().UTC()
+	if c.generated == nil {
+		c.generated = map[string]interface{}{}
+	}
+	c.generated["now"] = now
+	return now
+}
+
+// formatTime formats t with layout, which may be empty for RFC3339, a name
+// in namedTimeLayouts, unix or unixmilli for seconds or milliseconds since
+// the epoch, or a Go reference layout.
+func formatTime(t time.Time, layout string) interface{} {
+	switch layout {
+	case "":
+		return t.Format(time.RFC3339)
+	case "unix":
+		return t.Unix()
+	case "unixmilli":
+		return t.UnixMilli()
+	}
+	if named, ok := namedTimeLayouts[layout]; ok {
+		layout = named
+	}
+	return t.Format(layout)
+}
+
+func generateNow(c *Case, arg string) (interface{}, error) {
+	return formatTime(caseNow(c), arg), nil
+}
+
+func generateNowOffset(c *Case, arg string) (interface{}, error) {
+	offset, layout, _ := strings.Cut(arg, ",")
+	offset = strings.TrimSpace(offset)
+	var duration time.Duration
+	if days := strings.TrimSuffix(offset, "d"); days != offset {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return nil, fmt.Errorf("%w: $NOW_OFFSET[%s]: %v", ErrInvalidTemplateArgument, arg, err)
+		}
+		duration = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		duration, err = time.ParseDuration(offset)
+		if err != nil {
+			return nil, fmt.Errorf("%w: $NOW_OFFSET[%s]: %v", ErrInvalidTemplateArgument, arg, err)
+		}
+	}
+	return formatTime(caseNow(c).Add(duration), strings.TrimSpace(layout)), nil
+}
+
+func generateRandomInt(c *Case, arg string) (interface{}, error) {
+	low, high, ok := strings.Cut(arg, ",")
+	if !ok {
+		return nil, fmt.Errorf("%w: $RANDOM_INT[%s]: expected min,max", ErrInvalidTemplateArgument, arg)
+	}
+	min, err := strconv.ParseInt(strings.TrimSpace(low), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: $RANDOM_INT[%s]: %v", ErrInvalidTemplateArgument, arg, err)
+	}
+	max, err := strconv.ParseInt(strings.TrimSpace(high), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: $RANDOM_INT[%s]: %v", ErrInvalidTemplateArgument, arg, err)
+	}
+	if max < min {
+		return nil, fmt.Errorf("%w: $RANDOM_INT[%s]: max is less than min", ErrInvalidTemplateArgument, arg)
+	}
+	// The span is a big.Int as, for wide ranges, it overflows an int64.
+	offset := big.NewInt(min)
+	span := new(big.Int).Sub(big.NewInt(max), offset)
+	span.Add(span, big.NewInt(1))
+	n, err := rand.Int(rand.Reader, span)
+	if err != nil {
+		return nil, err
+	}
+	return int(n.Add(n, offset).Int64()), nil
+}
@@ -0,0 +1,47 @@
+package gobbi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path"
+)
+
+// SuiteTLS is the suite level TLS configuration, read from the tls key of
+// a suite YAML file. File names are relative to the suite file.
+type SuiteTLS struct {
+	CAFile   string `yaml:"ca_file,omitempty"`
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+}
+
+// Config creates a tls.Config from s, resolving files relative to the
+// suite file named by suiteFileName. A CA bundle is added to the system
+// pool of certificates.
+func (s *SuiteTLS) Config(suiteFileName string) (*tls.Config, error) {
+	dir := path.Dir(suiteFileName)
+	config := &tls.Config{}
+	if s.CAFile != "" {
+		pem, err := os.ReadFile(path.Join(dir, s.CAFile))
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: %s", ErrNoCertificates, s.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if s.CertFile != "" || s.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(path.Join(dir, s.CertFile), path.Join(dir, s.KeyFile))
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
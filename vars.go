@@ -0,0 +1,127 @@
+package gobbi
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const varRegexpString = `\$VAR(:(?P<cast>\w+))?\[(?:\\?"(?P<argD>.+?)\\?"|'(?P<argS>.+?)')\]`
+
+var (
+	ErrVarNotFound   = fmt.Errorf("%w: var not found", ErrTestError)
+	ErrCaptureFailed = fmt.Errorf("%w: unable to capture", ErrTestError)
+)
+
+// suiteVars are the vars of one run of a suite, shared by its cases.
+type suiteVars struct {
+	lock   sync.Mutex
+	values map[string]interface{}
+}
+
+func newSuiteVars(initial map[string]interface{}) *suiteVars {
+	values := make(map[string]interface{}, len(initial))
+	for name, v := range initial {
+		values[name] = v
+	}
+	return &suiteVars{values: values}
+}
+
+// GetVar returns the value of the var name and whether it is set.
+func (c *Case) GetVar(name string) (interface{}, bool) {
+	if c.vars == nil {
+		return nil, false
+	}
+	c.vars.lock.Lock()
+	defer c.vars.lock.Unlock()
+	v, ok := c.vars.values[name]
+	return v, ok
+}
+
+// SetVar sets the var name, for this and later cases in the suite.
+func (c *Case) SetVar(name string, v interface{}) {
+	if c.vars == nil {
+		c.vars = newSuiteVars(nil)
+	}
+	c.vars.lock.Lock()
+	defer c.vars.lock.Unlock()
+	c.vars.values[name] = v
+}
+
+func (c *Case) setVars(vars *suiteVars) {
+	c.vars = vars
+}
+
+// captureVars sets the vars named in Capture from the response. A value
+// which is a JSONPath, starting with $. or $[, is looked up in the JSON
+// response. Otherwise it is replaced as in later cases, so $RESPONSE,
+// $HEADERS and the like refer to this case.
+func (c *Case) captureVars() {
+	if len(c.Capture) == 0 {
+		return
+	}
+	// A case following this one, to resolve substitutions from. It shares
+	// the values of template functions, such as $UUID, with this case.
+	if c.generated == nil {
+		c.generated = map[string]interface{}{}
+	}
+	next := &Case{
+		URL:            c.URL,
+		prior:          c,
+		suiteFileName:  c.suiteFileName,
+		defaultURLBase: c.defaultURLBase,
+		test:           c.test,
+		vars:           c.vars,
+		generated:      c.generated,
+	}
+	for name, expr := range c.Capture {
+		if strings.HasPrefix(expr, "$.") || strings.HasPrefix(expr, "$[") {
+			quote := "'"
+			if strings.Contains(expr, "'") {
+				quote = `"`
+			}
+			expr = "$RESPONSE[" + quote + expr + quote + "]"
+		}
+		v, err := StringReplaceValue(next, expr)
+		if err != nil {
+			c.Errorf("%v", fmt.Errorf("%w: %s: %v", ErrCaptureFailed, name, err))
+			continue
+		}
+		c.SetVar(name, v)
+	}
+}
+
+// VarReplacer provides $VAR['name'], the value of the var name.
+type VarReplacer struct {
+	BaseStringReplacer
+}
+
+func (v *VarReplacer) Resolve(c *Case, argValue, cast string) (string, error) {
+	value, err := v.ResolveValue(c, argValue)
+	if err != nil {
+		return "", err
+	}
+	switch x := value.(type) {
+	case map[string]interface{}, []interface{}:
+		resp, err := json.Marshal(x)
+		return string(resp), err
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(x), nil
+	}
+}
+
+// ResolveValue returns the value of the var argValue, keeping its type.
+func (v *VarReplacer) ResolveValue(c *Case, argValue string) (interface{}, error) {
+	value, ok := c.GetVar(argValue)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrVarNotFound, argValue)
+	}
+	return value, nil
+}
+
+func (v *VarReplacer) Replace(c *Case, in string) (string, error) {
+	return baseReplace(v, c, in)
+}
@@ -0,0 +1,207 @@
+package gobbi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	ErrXPathNotMatched = fmt.Errorf("%w: xpath not matched", ErrTestFailure)
+)
+
+// XMLHandler asserts XPaths in XML responses and provides the $XPATH
+// replacer.
+type XMLHandler struct {
+	BaseStringReplacer
+	BaseResponseHandler
+}
+
+func (x *XMLHandler) Resolve(prior *Case, argValue, cast string) (string, error) {
+	output, err := x.ResolveValue(prior, argValue)
+	if err != nil {
+		return "", err
+	}
+	switch v := output.(type) {
+	case []interface{}:
+		resp, err := json.Marshal(v)
+		return string(resp), err
+	default:
+		return scalarToString(v), nil
+	}
+}
+
+// ResolveValue returns the result of the XPath argValue in the response to
+// prior. Nodes are represented by their text.
+func (x *XMLHandler) ResolveValue(prior *Case, argValue string) (interface{}, error) {
+	doc, err := x.ReadXMLResponse(prior)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateXPath(doc, argValue)
+}
+
+func (x *XMLHandler) Replace(c *Case, in string) (string, error) {
+	return baseReplace(x, c, in)
+}
+
+func (*XMLHandler) Accepts(c *Case) bool {
+	contentType := strings.TrimSpace(strings.Split(c.GetResponseHeader().Get("content-type"), ";")[0])
+	if !strings.HasSuffix(contentType, "/xml") && !strings.HasSuffix(contentType, "+xml") {
+		c.Errorf("response is not XML, must be to process XPath")
+		return false
+	}
+	return true
+}
+
+func (x *XMLHandler) Assert(c *Case) {
+	if len(c.ResponseXPaths) == 0 {
+		return
+	}
+
+	if !x.Accepts(c) {
+		return
+	}
+
+	doc, err := x.ReadXMLResponse(c)
+	if err != nil {
+		c.Fatalf("Unable to read XML from body: %v", err)
+	}
+
+	// Replace in both the paths and the expected values.
+	processedData, err := replaceData(c, c.ResponseXPaths)
+	if err != nil {
+		c.Fatalf("Unable to string replace XPaths: %v", err)
+	}
+
+	for path, v := range processedData.(map[string]interface{}) {
+		err := x.ProcessOneXPath(c, doc, path, v)
+		if err != nil {
+			c.Errorf("%v", err)
+		}
+	}
+}
+
+// ReadXMLResponse parses the body of the response to c.
+func (x *XMLHandler) ReadXMLResponse(c *Case) (*xmlquery.Node, error) {
+	_, err := c.GetResponseBody().Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return xmlquery.Parse(c.GetResponseBody())
+}
+
+// ReadXMLFromDisk reads the file named in stringData, evaluating an XPath
+// in it if one follows the first : in the filename. Without an XPath the
+// result is the content of the file with surrounding whitespace removed.
+func (x *XMLHandler) ReadXMLFromDisk(c *Case, stringData string) (interface{}, error) {
+	fileName, dataPath, hasPath := strings.Cut(stringData, ":")
+	fh, err := c.ReadFileForData(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if closer, ok := fh.(io.Closer); ok {
+		defer closer.Close()
+	}
+	if !hasPath {
+		rawBytes, err := io.ReadAll(fh)
+		if err != nil {
+			return nil, err
+		}
+		return strings.TrimSpace(string(rawBytes)), nil
+	}
+	doc, err := xmlquery.Parse(fh)
+	if err != nil {
+		return nil, err
+	}
+	return evaluateXPath(doc, dataPath)
+}
+
+func (x *XMLHandler) ProcessOneXPath(c *Case, doc *xmlquery.Node, path string, v interface{}) error {
+	if stringData, ok := v.(string); ok {
+		if strings.HasPrefix(stringData, fileForDataPrefix) {
+			var err error
+			v, err = x.ReadXMLFromDisk(c, stringData)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	output, err := evaluateXPath(doc, path)
+	if err != nil {
+		return err
+	}
+	if stringData, ok := v.(string); ok && isRegexp(stringData) {
+		actual, ok := output.(string)
+		if !ok {
+			actual = fmt.Sprintf("%v", output)
+		}
+		err := matchRegexp(stringData, actual)
+		if err != nil {
+			return fmt.Errorf("%w: for xpath %s", err, path)
+		}
+		return nil
+	}
+	expected, err := normalizeJSON(xmlExpected(v, output))
+	if err != nil {
+		return err
+	}
+	if !cmp.Equal(expected, output) {
+		return fmt.Errorf("%w: for xpath %s: diff: %s", ErrXPathNotMatched, path, cmp.Diff(expected, output))
+	}
+	return nil
+}
+
+// xmlExpected makes expected comparable with actual, the result of an
+// XPath. As text in XML has no type, an expected number or boolean is
+// compared with the text of a node as a string.
+func xmlExpected(expected, actual interface{}) interface{} {
+	switch a := actual.(type) {
+	case string:
+		if s := scalarToString(expected); s != "" {
+			return s
+		}
+	case []interface{}:
+		e, ok := expected.([]interface{})
+		if !ok || len(e) != len(a) {
+			return expected
+		}
+		converted := make([]interface{}, len(e))
+		for i := range e {
+			converted[i] = xmlExpected(e[i], a[i])
+		}
+		return converted
+	}
+	return expected
+}
+
+// evaluateXPath returns the result of path in doc, as a float64, string or
+// bool, or, for nodes, their text: a string for one node and a list for
+// more.
+func evaluateXPath(doc *xmlquery.Node, path string) (interface{}, error) {
+	expr, err := xpath.Compile(path)
+	if err != nil {
+		return nil, err
+	}
+	result := expr.Evaluate(xmlquery.CreateXPathNavigator(doc))
+	iterator, ok := result.(*xpath.NodeIterator)
+	if !ok {
+		return result, nil
+	}
+	values := []interface{}{}
+	for iterator.MoveNext() {
+		values = append(values, iterator.Current().Value())
+	}
+	switch len(values) {
+	case 0:
+		return nil, fmt.Errorf("%w: no nodes for xpath %s", ErrXPathNotMatched, path)
+	case 1:
+		return values[0], nil
+	}
+	return values, nil
+}